@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// exitAlert is the exit status used when a threshold is crossed and no
+// -exec command is configured.
+const exitAlert = 3
+
+// alertWatcher raises an alert once when the bid moves outside the
+// configured range and rearms when it comes back inside, so a quote that
+// stays above (or below) the threshold doesn't alert on every tick.
+type alertWatcher struct {
+	above    float64
+	below    float64
+	command  string
+	alerting bool
+}
+
+func (a *alertWatcher) enabled() bool {
+	return a.above > 0 || a.below > 0
+}
+
+func (a *alertWatcher) check(bid float64) {
+	var reason string
+	switch {
+	case a.above > 0 && bid > a.above:
+		reason = "above " + strconv.FormatFloat(a.above, 'f', -1, 64)
+	case a.below > 0 && bid < a.below:
+		reason = "below " + strconv.FormatFloat(a.below, 'f', -1, 64)
+	default:
+		a.alerting = false
+		return
+	}
+	if a.alerting {
+		return
+	}
+	a.alerting = true
+
+	log.Printf("ALERT: dollar at %s crossed %s\n", strconv.FormatFloat(bid, 'f', 4, 64), reason)
+	if a.command == "" {
+		os.Exit(exitAlert)
+	}
+
+	cmd := exec.Command("sh", "-c", a.command)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("BID=%v", bid), "ALERT="+reason)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("Error running alert command: %v\n", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const timeoutBackfill = 5 * time.Second
+
+type backfillResult struct {
+	Added   int `json:"added"`
+	Steps   int `json:"steps"`
+	Skipped int `json:"skipped"`
+}
+
+// runBackfill asks the server to fill the gaps in its stored history over
+// the last -since, one row per empty -step, and reports how many it added.
+// It is an admin operation, so the credentials go in -H.
+func runBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	since := flags.Duration("since", 24*time.Hour, "how far back to fill gaps")
+	step := flags.Duration("step", 5*time.Minute, "resolution to fill gaps at, in whole seconds")
+	headers := headerFlags{}
+	flags.Var(headers, "H", `header to send, e.g. "X-API-Key: secret" (repeatable)`)
+	conn := addConnectionFlags(flags)
+	flags.Parse(args)
+	conn.apply()
+
+	if *since <= 0 || *step < time.Second || *step%time.Second != 0 {
+		log.Printf("Invalid -since or -step: -since must be positive and -step whole seconds, at least 1s\n")
+		os.Exit(2)
+	}
+
+	result, err := backfill(time.Now().Add(-*since), *step, headers)
+	if err != nil {
+		log.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf(
+		"Backfilled %d quotes over %d steps of %v (%d gaps skipped with nothing stored before or after)\n",
+		result.Added, result.Steps, *step, result.Skipped,
+	)
+}
+
+func backfill(from time.Time, step time.Duration, headers headerFlags) (*backfillResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutBackfill)
+	defer cancel()
+
+	query := url.Values{}
+	query.Set("from", from.UTC().Format(time.RFC3339))
+	query.Set("step", step.String())
+	req, err := http.NewRequestWithContext(ctx, "POST", serverURL+"/admin/backfill?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request: %v", err)
+	}
+	headers.apply(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response body: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Error response from server: %s", string(body))
+	}
+
+	var result backfillResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("Error decoding JSON: %v", err)
+	}
+	return &result, nil
+}
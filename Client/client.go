@@ -1,69 +1,445 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
+const (
+	defaultServerURL = "http://localhost:8080"
+
+	timeoutFetch  = 300 * time.Millisecond
+	timeoutImport = 5 * time.Second
+)
+
+// serverURL is the server every command talks to, set by -server.
+var serverURL = defaultServerURL
+
+// httpClient is shared by every request so interval polling reuses the
+// same keep-alive connection instead of dialing the server on each tick.
+var httpClient = newHTTPClient(nil)
+
+// newHTTPClient builds the client with tlsConfig for https servers; nil
+// verifies them against the system roots.
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 4
+	transport.MaxIdleConnsPerHost = 2
+	transport.IdleConnTimeout = 90 * time.Second
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}
+}
+
+type fetchOptions struct {
+	url       string
+	outputs   []outputTarget
+	statePath string
+	field     string
+	locale    string
+	encoding  string
+	ndjson    bool
+	raw       bool
+	stats     bool
+	diff      *diffTracker
+	headers   headerFlags
+
+	// maxAge, when set, rejects quotes whose create_date is older.
+	maxAge time.Duration
+
+	// webhook, when set, is POSTed every new quote.
+	webhook string
+
+	retry retryPolicy
+}
+
+type ndjsonLine struct {
+	Timestamp time.Time `json:"ts"`
+	Bid       float64   `json:"bid"`
+	Source    string    `json:"source,omitempty"`
+}
+
 func main() {
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
-	defer cancel()
+	args := os.Args[1:]
+	command := "fetch"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "fetch":
+		runFetch("fetch", args, 0)
+	case "watch":
+		runFetch("watch", args, 5*time.Second)
+	case "import":
+		runImport(args)
+	case "history":
+		runHistory(args)
+	case "tail":
+		runTail(args)
+	case "status":
+		runStatus(args)
+	case "backfill":
+		runBackfill(args)
+	default:
+		log.Printf("Unknown command %q (expected fetch, watch, import, history, tail, status or backfill)\n", command)
+		os.Exit(2)
+	}
+}
+
+// runFetch runs the fetch command, and watch, which is fetch polling every
+// defaultInterval unless told otherwise.
+func runFetch(name string, args []string, defaultInterval time.Duration) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	interval := flags.Duration("interval", defaultInterval, "poll the server at this interval instead of fetching once")
+	conn := addConnectionFlags(flags)
+	opts := &fetchOptions{headers: headerFlags{}}
+	var outs outputFlags
+	flags.Var(&outs, "out", `file the quote is written to, as path[:text|json] (repeatable; default "`+defaultOutputFile+`")`)
+	fallbackDir := flags.String("fallback-dir", os.TempDir(), "directory used when the default output location isn't writable")
+	flags.StringVar(&opts.field, "field", "bid", "dotted JSON path of the quote value in the server response")
+	flags.StringVar(&opts.locale, "locale", "", "format the output for this locale, e.g. pt-BR or en-US")
+	flags.StringVar(&opts.encoding, "encoding", encodingUTF8, "encoding of text output files: utf8, utf8-bom or latin1")
+	flags.BoolVar(&opts.ndjson, "ndjson", false, "print each fetched quote to stdout as a JSON line")
+	flags.BoolVar(&opts.raw, "raw", false, "print only the bid to stdout, e.g. for rate=$(client fetch -raw)")
+	flags.BoolVar(&opts.stats, "stats", false, "log each request's HTTP status, round-trip time and response size to stderr")
+	flags.Var(opts.headers, "H", `header to send with each request, as "Name: value" (repeatable)`)
+	diff := flags.Bool("diff", false, "print each bid with its change since the previous tick")
+	flags.DurationVar(&opts.retry.deadline, "retry-deadline", 10*time.Second, "how long after the first attempt retries may still start")
+	flags.IntVar(&opts.retry.budget, "retry-budget", 3, "how many times a failed fetch is retried at most; 0 disables retrying")
+	flags.Float64Var(&opts.retry.jitter, "retry-jitter", 0.5, "random extra wait added to each retry, as a fraction (0 to 1) of the wait")
+	flags.DurationVar(&opts.maxAge, "max-age", 0, "fail, without writing, when the quote was created longer ago than this")
+	flags.StringVar(&opts.webhook, "webhook", "", "URL every new quote is POSTed to as JSON")
+	webhookOnly := flags.Bool("webhook-only", false, "post to -webhook without writing any output file")
+	alert := &alertWatcher{}
+	flags.Float64Var(&alert.above, "alert-above", 0, "alert when the bid rises above this value")
+	flags.Float64Var(&alert.below, "alert-below", 0, "alert when the bid falls below this value")
+	flags.StringVar(&alert.command, "exec", "", "shell command to run on alert instead of exiting")
+	flags.Parse(args)
+	conn.apply()
+	if opts.maxAge < 0 {
+		log.Printf("Invalid -max-age: must not be negative\n")
+		os.Exit(2)
+	}
+	query := url.Values{}
+	if opts.maxAge > 0 {
+		// Only verbose answers carry the quote's create_date.
+		query.Set("verbose", "true")
+	}
+	if opts.stats {
+		// Makes the server send Server-Timing with its processing time. A
+		// timed answer has no ETag, so every fetch is then a full one.
+		query.Set("timing", "true")
+	}
+	opts.url = serverURL + "/cotacao"
+	if len(query) > 0 {
+		opts.url += "?" + query.Encode()
+	}
+
+	if *diff {
+		opts.diff = &diffTracker{}
+	}
+	modes := 0
+	for _, on := range []bool{opts.raw, opts.ndjson, *diff} {
+		if on {
+			modes++
+		}
+	}
+	if modes > 1 {
+		log.Printf("Only one of -raw, -ndjson and -diff can be given\n")
+		os.Exit(2)
+	}
+	if opts.retry.budget < 0 || opts.retry.jitter < 0 || opts.retry.jitter > 1 {
+		log.Printf("Invalid retry settings: -retry-budget must not be negative and -retry-jitter must be 0 to 1\n")
+		os.Exit(2)
+	}
+	if _, err := formatQuotation(0, opts.locale); err != nil {
+		log.Printf("%v\n", err)
+		os.Exit(2)
+	}
+	if _, err := encodeText("", opts.encoding); err != nil {
+		log.Printf("%v\n", err)
+		os.Exit(2)
+	}
+	if opts.webhook != "" {
+		if err := checkWebhookURL(opts.webhook); err != nil {
+			log.Printf("%v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *webhookOnly && (opts.webhook == "" || len(outs) > 0) {
+		log.Printf("-webhook-only needs -webhook and can't be given with -out\n")
+		os.Exit(2)
+	}
+
+	explicitOut := len(outs) > 0
+	if !explicitOut {
+		outs = outputFlags{{path: defaultOutputFile, format: formatText}}
+	}
+	for _, target := range outs {
+		path, err := resolveOutputPath(target.path, explicitOut, *fallbackDir)
+		if err != nil {
+			log.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		opts.outputs = append(opts.outputs, outputTarget{path: path, format: target.format})
+	}
+	opts.statePath = filepath.Join(filepath.Dir(opts.outputs[0].path), stateFile)
+	if *webhookOnly {
+		// The state file still goes where the default output would.
+		opts.outputs = nil
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://localhost:8080/cotacao", nil)
+	state, err := loadFetchState(opts.statePath)
 	if err != nil {
-		log.Printf("Error creating request: %v\n", err)
+		log.Printf("%v\n", err)
+		state = &fetchState{}
+	}
+
+	tick := func() {
+		if bid, ok := fetchQuotation(opts, state); ok && alert.enabled() {
+			alert.check(bid)
+		}
+	}
+
+	if *interval <= 0 {
+		tick()
 		return
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		tick()
+		<-ticker.C
+	}
+}
+
+// fetchQuotation fetches and writes out the current quote, reporting the
+// result on stdout, and returns the bid and whether the whole tick
+// succeeded.
+func fetchQuotation(opts *fetchOptions, state *fetchState) (float64, bool) {
+	bid, changed, err := fetchAndWrite(opts, state)
 	if err != nil {
-		log.Printf("Error sending request: %v\n", err)
-		return
+		log.Printf("%v\n", err)
+		if errors.Is(err, syscall.ENOSPC) {
+			os.Exit(exitDiskFull)
+		}
+		if isAgeError(err) {
+			os.Exit(exitTooOld)
+		}
+		if isWebhookError(err) {
+			os.Exit(exitWebhook)
+		}
+		return 0, false
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		body, readErr := io.ReadAll(resp.Body)
-		if readErr != nil {
-			log.Printf("Error reading response body: %v\n", readErr)
-			return
+	if opts.raw {
+		fmt.Printf("%.2f\n", bid)
+		return bid, true
+	}
+	if opts.diff != nil {
+		fmt.Println(opts.diff.line(bid))
+		return bid, true
+	}
+	if opts.ndjson {
+		line, err := json.Marshal(ndjsonLine{Timestamp: time.Now().UTC(), Bid: bid, Source: state.Source})
+		if err != nil {
+			log.Printf("Error encoding JSON line: %v\n", err)
+			return 0, false
 		}
-		log.Printf("Error response from server: %s\n", string(body))
+		fmt.Println(string(line))
+		return bid, true
+	}
+
+	if !changed {
+		fmt.Println("Dollar quotation unchanged" + sourceNote(state.Source))
+		return bid, true
+	}
+	if len(opts.outputs) == 0 {
+		fmt.Println("Dollar quotation posted to webhook" + sourceNote(state.Source))
+		return bid, true
+	}
+	fmt.Println("Dollar quotation saved successfully" + sourceNote(state.Source))
+	return bid, true
+}
+
+// warnIfStale logs a warning when the server flagged its answer as a stored
+// quote standing in for an unreachable provider.
+func warnIfStale(data interface{}) {
+	response, ok := data.(map[string]interface{})
+	if !ok || response["stale"] != true {
 		return
 	}
+	age := "unknown"
+	if seconds, ok := response["age_seconds"].(json.Number); ok {
+		age = seconds.String() + "s"
+	}
+	log.Printf("Warning: the server can't reach its provider; this quote is stale (age %s)\n", age)
+}
+
+// sourceNote tells, for the status line, when the server answered with
+// anything but a live quote.
+func sourceNote(source string) string {
+	if source == "" || source == "live" {
+		return ""
+	}
+	return fmt.Sprintf(" (source: %s)", source)
+}
+
+// fetchAndWrite gets the current bid from opts.url and, when it changed,
+// writes it to every one of opts.outputs, posts it to opts.webhook and
+// saves state. When the server answers that it didn't change, only the
+// outputs that don't hold it yet are written, such as a deleted file, a new
+// -out or one rendered with another -locale or -encoding. It reports whether
+// anything was written, and holds the whole fetch without printing
+// anything, so it can be pointed at any server.
+func fetchAndWrite(opts *fetchOptions, state *fetchState) (float64, bool, error) {
+	var bid float64
+	var changed bool
+	err := withBackoff(opts.retry, func() (err error) {
+		bid, changed, err = getQuotation(opts, state)
+		return err
+	})
+	if err != nil {
+		return bid, false, err
+	}
+
+	contents := make([][]byte, len(opts.outputs))
+	for i, target := range opts.outputs {
+		if contents[i], err = renderOutput(target.format, bid, opts.locale, opts.encoding, state.Source); err != nil {
+			return 0, false, err
+		}
+	}
+	targets := opts.outputs
+	if !changed {
+		if targets, contents = outdatedOutputs(targets, contents); len(targets) == 0 {
+			return bid, false, nil
+		}
+	}
+	if err := writeOutputs(targets, contents); err != nil {
+		return 0, false, err
+	}
+	if !changed {
+		// The webhook and state already have this quote.
+		return bid, true, nil
+	}
+	// State is only saved once the webhook took the quote, so a failed
+	// post is tried again by the next run rather than answered with a 304.
+	if opts.webhook != "" {
+		if err := postWebhook(opts.webhook, opts.retry, bid, state.Source); err != nil {
+			return 0, false, err
+		}
+	}
+	if err := state.save(opts.statePath); err != nil {
+		log.Printf("%v\n", err)
+	}
+	return bid, true, nil
+}
+
+// getQuotation asks opts.url for the current bid, read from opts.field,
+// sending the ETag from state so an unchanged quote comes back as 304. It
+// updates state and reports whether the bid changed since the previous
+// response.
+func getQuotation(opts *fetchOptions, state *fetchState) (float64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutFetch)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", opts.url, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("Error creating request: %v", err)
+	}
+	opts.headers.apply(req)
+	// Without a stored create_date a 304 couldn't be checked against maxAge,
+	// and the stored bid is only the current one when read from the same
+	// field.
+	if state.ETag != "" && state.Field == opts.field && (opts.maxAge == 0 || state.CreateDate != nil) {
+		req.Header.Set("If-None-Match", state.ETag)
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if opts.stats {
+			logStats(nil, time.Since(start), 0)
+		}
+		err = fmt.Errorf("Error sending request: %w", err)
+		// A certificate that fails verification won't pass on a retry.
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return 0, false, err
+		}
+		return 0, false, &retryableError{err: err}
+	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
+	if opts.stats {
+		logStats(resp, time.Since(start), len(body))
+	}
 	if err != nil {
-		log.Printf("Error reading response body: %v\n", err)
-		return
+		return 0, false, fmt.Errorf("Error reading response body: %v", err)
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		log.Printf("Error decoding JSON: %v\n", err)
-		return
+	state.Source = resp.Header.Get("X-Quote-Source")
+	if resp.StatusCode == http.StatusNotModified {
+		if opts.maxAge > 0 {
+			if err := checkAge(*state.CreateDate, opts.maxAge); err != nil {
+				return 0, false, err
+			}
+		}
+		return state.Bid, false, nil
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return 0, false, errors.New("No quotation available yet")
 	}
 
-	bid, ok := data["bid"].(float64)
-	if !ok {
-		log.Printf("Invalid response format: quote value not found or not a number\n")
-		return
+	if resp.StatusCode >= 400 {
+		return 0, false, serverError(resp, body)
 	}
 
-	bidStr := strconv.FormatFloat(bid, 'f', 2, 64)
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return 0, false, fmt.Errorf("Error decoding JSON: %v", err)
+	}
 
-	content := []byte("Dólar:" + bidStr)
-	if err := os.WriteFile("cotacao.txt", content, 0644); err != nil {
-		log.Printf("Error writing to file: %v\n", err)
-		return
+	bid, err := extractNumber(data, opts.field)
+	if err != nil {
+		return 0, false, err
 	}
+	warnIfStale(data)
 
-	fmt.Println("Dollar quotation saved successfully")
+	// A rejected quote leaves state alone, so it is never taken as written.
+	var created *time.Time
+	if opts.maxAge > 0 {
+		at, err := createdAt(data)
+		if err != nil {
+			return 0, false, err
+		}
+		if err := checkAge(at, opts.maxAge); err != nil {
+			return 0, false, err
+		}
+		created = &at
+	}
+	state.CreateDate = created
+	state.ETag = resp.Header.Get("ETag")
+	state.Bid = bid
+	state.Field = opts.field
+	return bid, true, nil
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// conditionalServer answers /cotacao with bid under etag, and with 304 to a
+// request that already has it.
+func conditionalServer(t *testing.T, bid, etag string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"bid":%s}`, bid)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testOptions fetches from url into dir's cotacao.txt, keeping state there,
+// without retrying.
+func testOptions(url, dir string) *fetchOptions {
+	return &fetchOptions{
+		url:       url,
+		outputs:   []outputTarget{{path: filepath.Join(dir, defaultOutputFile), format: formatText}},
+		statePath: filepath.Join(dir, stateFile),
+		field:     "bid",
+		encoding:  encodingUTF8,
+		headers:   headerFlags{},
+	}
+}
+
+func readOutput(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestNotModifiedRewritesMissingOutput(t *testing.T) {
+	srv := conditionalServer(t, "5.12", `"q1"`)
+	dir := t.TempDir()
+	opts := testOptions(srv.URL, dir)
+	state := &fetchState{}
+	if _, _, err := fetchAndWrite(opts, state); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(opts.outputs[0].path); err != nil {
+		t.Fatal(err)
+	}
+	_, wrote, err := fetchAndWrite(opts, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wrote {
+		t.Error("deleted output not reported as written")
+	}
+	if got := readOutput(t, opts.outputs[0].path); got != "Dólar:5.12" {
+		t.Errorf("output %q, want Dólar:5.12", got)
+	}
+}
+
+func TestNotModifiedWritesNewTarget(t *testing.T) {
+	srv := conditionalServer(t, "5.12", `"q1"`)
+	dir := t.TempDir()
+	opts := testOptions(srv.URL, dir)
+	state := &fetchState{}
+	if _, _, err := fetchAndWrite(opts, state); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonPath := filepath.Join(dir, "q.json")
+	opts.outputs = append(opts.outputs, outputTarget{path: jsonPath, format: formatJSON})
+	if _, _, err := fetchAndWrite(opts, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(jsonPath); err != nil {
+		t.Errorf("new JSON target not written: %v", err)
+	}
+
+	// Once every target is up to date a 304 writes nothing.
+	_, wrote, err := fetchAndWrite(opts, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrote {
+		t.Error("up-to-date outputs written again")
+	}
+}
+
+func TestNotModifiedAppliesNewFormatting(t *testing.T) {
+	srv := conditionalServer(t, "5.12", `"q1"`)
+	dir := t.TempDir()
+	opts := testOptions(srv.URL, dir)
+	state := &fetchState{}
+	if _, _, err := fetchAndWrite(opts, state); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.locale = "pt-BR"
+	if _, _, err := fetchAndWrite(opts, state); err != nil {
+		t.Fatal(err)
+	}
+	if got := readOutput(t, opts.outputs[0].path); got != "Dólar:5,12" {
+		t.Errorf("after -locale pt-BR, output %q, want Dólar:5,12", got)
+	}
+
+	opts.encoding = encodingLatin1
+	if _, _, err := fetchAndWrite(opts, state); err != nil {
+		t.Fatal(err)
+	}
+	if got := readOutput(t, opts.outputs[0].path); got != "D\xf3lar:5,12" {
+		t.Errorf("after -encoding latin1, output %q, want Latin-1 Dólar:5,12", got)
+	}
+}
+
+func TestFetchAndWriteWritesQuote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"bid":5.12}`)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	opts := testOptions(srv.URL, dir)
+
+	bid, wrote, err := fetchAndWrite(opts, &fetchState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bid != 5.12 || !wrote {
+		t.Errorf("got bid %v, wrote %v; want 5.12, true", bid, wrote)
+	}
+	if got := readOutput(t, opts.outputs[0].path); got != "Dólar:5.12" {
+		t.Errorf("output %q, want Dólar:5.12", got)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// diffTracker formats each bid along with its change since the previous
+// tick, as in "5.12 (+0.01)". The first tick has nothing to compare with
+// and prints the bid alone.
+type diffTracker struct {
+	previous float64
+	seen     bool
+}
+
+func (d *diffTracker) line(bid float64) string {
+	defer func() { d.previous, d.seen = bid, true }()
+	if !d.seen {
+		return fmt.Sprintf("%.2f", bid)
+	}
+	// Round before formatting so a tiny drop prints as +0.00, not -0.00.
+	delta := math.Round((bid-d.previous)*100) / 100
+	if delta == 0 {
+		delta = 0
+	}
+	return fmt.Sprintf("%.2f (%+.2f)", bid, delta)
+}
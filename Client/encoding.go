@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Encodings a text output file can be written in, for -encoding.
+const (
+	encodingUTF8    = "utf8"
+	encodingUTF8BOM = "utf8-bom"
+	encodingLatin1  = "latin1"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// encodeText encodes a text output line, "Dólar:5.12", for tools that
+// misread plain UTF-8: with a byte order mark, or as Latin-1.
+func encodeText(line, encoding string) ([]byte, error) {
+	switch encoding {
+	case encodingUTF8:
+		return []byte(line), nil
+	case encodingUTF8BOM:
+		return append(append([]byte{}, utf8BOM...), line...), nil
+	case encodingLatin1:
+		data, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("Error encoding %q as Latin-1: %v", line, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf(
+		"Invalid encoding %q: expected %s, %s or %s",
+		encoding, encodingUTF8, encodingUTF8BOM, encodingLatin1,
+	)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// extractNumber walks a dotted path such as "quotes.0.bid" through decoded
+// JSON, indexing objects by key and arrays by position, and returns the
+// number found there. Numbers may be decoded as json.Number or float64, and
+// numeric strings are accepted as well.
+func extractNumber(data interface{}, path string) (float64, error) {
+	current := data
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[part]
+			if !ok {
+				return 0, fmt.Errorf("Invalid response format: field %q not found", path)
+			}
+			current = value
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return 0, fmt.Errorf("Invalid response format: field %q not found", path)
+			}
+			current = node[i]
+		default:
+			return 0, fmt.Errorf("Invalid response format: field %q not found", path)
+		}
+	}
+
+	switch value := current.(type) {
+	case json.Number:
+		if n, err := value.Float64(); err == nil {
+			return n, nil
+		}
+	case float64:
+		return value, nil
+	case string:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid response format: field %q is not a number", path)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// quoteLabels holds the output label per language; other languages use the
+// currency code.
+var quoteLabels = map[string]string{
+	"pt": "Dólar",
+	"es": "Dólar",
+	"en": "Dollar",
+}
+
+// formatQuotation renders the output file line for bid. Without a locale it
+// keeps the original "Dólar:5.12" format; with one, the label and the
+// decimal and thousands separators follow that locale (pt-BR: "Dólar:5,12").
+func formatQuotation(bid float64, locale string) (string, error) {
+	if locale == "" {
+		return "Dólar:" + strconv.FormatFloat(bid, 'f', 2, 64), nil
+	}
+
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid locale %q: %v", locale, err)
+	}
+	base, _ := tag.Base()
+	label, ok := quoteLabels[base.String()]
+	if !ok {
+		label = "USD"
+	}
+
+	printer := message.NewPrinter(tag)
+	return label + ":" + printer.Sprint(number.Decimal(bid, number.Scale(2))), nil
+}
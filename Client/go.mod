@@ -1,3 +1,5 @@
 module github.com/ankardo/Client-Server-API/Client
 
 go 1.22.3
+
+require golang.org/x/text v0.21.0
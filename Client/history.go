@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	historyPageSize = 500
+	timeoutHistory  = 2 * time.Second
+)
+
+// runHistory exports the quotes stored over the last -since as CSV, in the
+// same timestamp,bid,create_date layout the import command reads.
+func runHistory(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	since := flags.Duration("since", 24*time.Hour, "how far back to export")
+	out := flags.String("out", "", "CSV file to write (default stdout)")
+	conn := addConnectionFlags(flags)
+	flags.Parse(args)
+	conn.apply()
+
+	if *since <= 0 {
+		log.Printf("Invalid -since: must be positive\n")
+		os.Exit(2)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Printf("Error creating output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := exportHistory(w, time.Now().Add(-*since))
+	if err != nil {
+		log.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if *out != "" {
+		fmt.Printf("Exported %d quotes to %s\n", count, *out)
+	}
+}
+
+// exportHistory follows X-Total-Count across pages until every quote since
+// from has been written.
+func exportHistory(w io.Writer, from time.Time) (int, error) {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"timestamp", "bid", "create_date"})
+
+	written := 0
+	for {
+		quotes, total, err := getHistoryPage(from, written)
+		if err != nil {
+			return written, err
+		}
+		for _, quote := range quotes {
+			writer.Write([]string{
+				strconv.FormatInt(quote.Timestamp, 10),
+				strconv.FormatFloat(quote.Bid, 'f', -1, 64),
+				quote.CreateDate.UTC().Format(createDateLayout),
+			})
+		}
+		written += len(quotes)
+		if len(quotes) == 0 || written >= total {
+			break
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return written, fmt.Errorf("Error writing CSV: %v", err)
+	}
+	return written, nil
+}
+
+func getHistoryPage(from time.Time, offset int) ([]quoteRow, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutHistory)
+	defer cancel()
+
+	params := url.Values{}
+	params.Set("from", from.UTC().Format(time.RFC3339))
+	params.Set("limit", strconv.Itoa(historyPageSize))
+	params.Set("offset", strconv.Itoa(offset))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL+"/cotacao/history?"+params.Encode(), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error creating request: %v", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error reading response body: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, 0, fmt.Errorf("Error response from server: %s", string(body))
+	}
+
+	var quotes []quoteRow
+	if err := json.Unmarshal(body, &quotes); err != nil {
+		return nil, 0, fmt.Errorf("Error decoding JSON: %v", err)
+	}
+	total, err := strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("Invalid X-Total-Count header: %q", resp.Header.Get("X-Total-Count"))
+	}
+	return quotes, total, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const createDateLayout = "2006-01-02 15:04:05"
+
+type quoteRow struct {
+	Bid        float64   `json:"bid"`
+	Timestamp  int64     `json:"timestamp"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+type importResult struct {
+	Imported int `json:"imported"`
+}
+
+// runImport parses the import command's flags and imports its one file.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	conn := addConnectionFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Printf("Usage: client import [-server URL] <file.csv>\n")
+		os.Exit(2)
+	}
+	conn.apply()
+	importQuotations(flags.Arg(0))
+}
+
+// importQuotations reads a CSV of timestamp,bid,create_date rows and posts
+// the valid ones to the server in a single batch. The batch is keyed by its
+// content hash, so sending the same rows again doesn't duplicate them.
+func importQuotations(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error opening import file: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	quotes, skipped, err := readQuotesCSV(file)
+	if err != nil {
+		log.Printf("Error reading import file: %v\n", err)
+		return
+	}
+	if len(quotes) == 0 {
+		log.Printf("No valid quotes found in %s (%d lines skipped)\n", path, skipped)
+		return
+	}
+
+	payload, err := json.Marshal(quotes)
+	if err != nil {
+		log.Printf("Error encoding quotes: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutImport)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		serverURL+"/cotacao/import",
+		bytes.NewReader(payload),
+	)
+	if err != nil {
+		log.Printf("Error creating request: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	digest := sha256.Sum256(payload)
+	req.Header.Set("Idempotency-Key", hex.EncodeToString(digest[:]))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error sending request: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Error reading response body: %v\n", err)
+		return
+	}
+	if resp.StatusCode >= 400 {
+		log.Printf("Error response from server: %s\n", string(body))
+		return
+	}
+
+	var result importResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Printf("Error decoding JSON: %v\n", err)
+		return
+	}
+
+	if resp.Header.Get("Idempotent-Replayed") == "true" {
+		fmt.Printf("Already imported %d quotes from this file earlier, nothing to do\n", result.Imported)
+		return
+	}
+	fmt.Printf("Imported %d quotes, skipped %d malformed lines\n", result.Imported, skipped)
+}
+
+// readQuotesCSV parses every record it can, warning about and counting the
+// lines that are malformed instead of aborting the whole import. A leading
+// header line is accepted and ignored.
+func readQuotesCSV(r io.Reader) ([]quoteRow, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var quotes []quoteRow
+	skipped := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			log.Printf("Skipping line %d: %v\n", parseErr.Line, parseErr.Err)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, skipped, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "timestamp") {
+			continue
+		}
+
+		quote, err := parseQuoteRecord(record)
+		if err != nil {
+			log.Printf("Skipping line %d: %v\n", line, err)
+			skipped++
+			continue
+		}
+		quotes = append(quotes, quote)
+	}
+
+	return quotes, skipped, nil
+}
+
+func parseQuoteRecord(record []string) (quoteRow, error) {
+	if len(record) != 3 {
+		return quoteRow{}, fmt.Errorf("expected 3 fields, got %d", len(record))
+	}
+
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(record[0]), 10, 64)
+	if err != nil || timestamp <= 0 {
+		return quoteRow{}, fmt.Errorf("invalid timestamp %q", record[0])
+	}
+
+	bid, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+	if err != nil || bid <= 0 {
+		return quoteRow{}, fmt.Errorf("invalid bid %q", record[1])
+	}
+
+	createDate, err := time.Parse(createDateLayout, strings.TrimSpace(record[2]))
+	if err != nil {
+		return quoteRow{}, fmt.Errorf("invalid create_date %q", record[2])
+	}
+
+	return quoteRow{
+		Bid:        bid,
+		Timestamp:  timestamp,
+		CreateDate: createDate,
+	}, nil
+}
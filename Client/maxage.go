@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// exitTooOld is the exit status used when -max-age rejects a quote, in
+// watch mode too, so a script never carries on with a stale rate.
+const exitTooOld = 5
+
+// ageError is a quote that -max-age rejects, being too old or of unknown
+// age.
+type ageError struct {
+	err error
+}
+
+func (e *ageError) Error() string { return e.err.Error() }
+func (e *ageError) Unwrap() error { return e.err }
+
+func isAgeError(err error) bool {
+	var ageErr *ageError
+	return errors.As(err, &ageErr)
+}
+
+// createdAt reads the quote's create_date from a verbose response. It
+// fails when the server doesn't send one, since the quote's age is then
+// unknown.
+func createdAt(data interface{}) (time.Time, error) {
+	response, _ := data.(map[string]interface{})
+	raw, ok := response["create_date"].(string)
+	if !ok {
+		return time.Time{}, &ageError{errors.New("Error checking the quote's age: the server didn't send create_date with verbose=true")}
+	}
+	created, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, &ageError{fmt.Errorf("Error checking the quote's age: invalid create_date %q", raw)}
+	}
+	return created, nil
+}
+
+// checkAge fails when a quote created at created is older than maxAge.
+func checkAge(created time.Time, maxAge time.Duration) error {
+	age := time.Since(created)
+	if age <= maxAge {
+		return nil
+	}
+	return &ageError{fmt.Errorf(
+		"Error: the quote is %s old, over -max-age %s; not writing it",
+		age.Round(time.Second), maxAge,
+	)}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultOutputFile = "cotacao.txt"
+
+// Output formats: the "Dólar:5.12" line, or a JSON object like the -ndjson
+// lines.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+type outputTarget struct {
+	path   string
+	format string
+}
+
+// outputFlags collects repeated -out path[:format] flags. The suffix only
+// counts as a format when it names one, so paths containing ':' still work.
+type outputFlags []outputTarget
+
+func (o *outputFlags) String() string {
+	var targets []string
+	for _, target := range *o {
+		targets = append(targets, target.path+":"+target.format)
+	}
+	return strings.Join(targets, ", ")
+}
+
+func (o *outputFlags) Set(raw string) error {
+	target := outputTarget{path: raw, format: formatText}
+	if i := strings.LastIndex(raw, ":"); i >= 0 {
+		if format := raw[i+1:]; format == formatText || format == formatJSON {
+			target = outputTarget{path: raw[:i], format: format}
+		}
+	}
+	if target.path == "" {
+		return fmt.Errorf("expected path[:text|json], got %q", raw)
+	}
+	for _, other := range *o {
+		if filepath.Clean(other.path) == filepath.Clean(target.path) {
+			return fmt.Errorf("%s is given more than once", target.path)
+		}
+	}
+	*o = append(*o, target)
+	return nil
+}
+
+// renderOutput is the content target.format gives bid. Text is written in
+// encoding; JSON is always UTF-8.
+func renderOutput(format string, bid float64, locale, encoding, source string) ([]byte, error) {
+	if format == formatJSON {
+		data, err := json.Marshal(ndjsonLine{Timestamp: time.Now().UTC(), Bid: bid, Source: source})
+		if err != nil {
+			return nil, fmt.Errorf("Error encoding JSON output: %v", err)
+		}
+		return append(data, '\n'), nil
+	}
+	line, err := formatQuotation(bid, locale)
+	if err != nil {
+		return nil, err
+	}
+	return encodeText(line, encoding)
+}
+
+// outdatedOutputs returns the targets, with their contents, whose file
+// doesn't hold its content yet. A JSON file carries the time it was written,
+// so it only counts as outdated when its quote differs.
+func outdatedOutputs(targets []outputTarget, contents [][]byte) ([]outputTarget, [][]byte) {
+	var outdated []outputTarget
+	var outdatedContents [][]byte
+	for i, target := range targets {
+		current, err := os.ReadFile(target.path)
+		if err == nil && sameOutput(target.format, current, contents[i]) {
+			continue
+		}
+		outdated = append(outdated, target)
+		outdatedContents = append(outdatedContents, contents[i])
+	}
+	return outdated, outdatedContents
+}
+
+func sameOutput(format string, current, content []byte) bool {
+	if format != formatJSON {
+		return bytes.Equal(current, content)
+	}
+	var have, want ndjsonLine
+	return json.Unmarshal(current, &have) == nil && json.Unmarshal(content, &want) == nil &&
+		have.Bid == want.Bid && have.Source == want.Source
+}
+
+// writeOutputs writes every target's content to a temporary file next to
+// it and only renames them all into place once each was written. Should a
+// rename still fail, the targets already replaced are rolled back from
+// hard-link backups, so a failed write leaves every target as it was.
+func writeOutputs(targets []outputTarget, contents [][]byte) error {
+	temps := make([]string, 0, len(targets))
+	backups := make([]string, len(targets))
+	defer func() {
+		for _, name := range append(temps, backups...) {
+			if name != "" {
+				os.Remove(name)
+			}
+		}
+	}()
+
+	for i, target := range targets {
+		temp, err := writeTemp(target.path, contents[i])
+		if temp != "" {
+			temps = append(temps, temp)
+		}
+		if err != nil {
+			return writeError("to file", target.path, err)
+		}
+		if backups[i], err = backup(target.path); err != nil {
+			return writeError("to file", target.path, err)
+		}
+	}
+	for i, target := range targets {
+		if err := os.Rename(temps[i], target.path); err != nil {
+			restore(targets[:i], backups)
+			return writeError("to file", target.path, err)
+		}
+		temps[i] = ""
+	}
+	return nil
+}
+
+// backup hard-links path under a temporary name, so it can be put back
+// after being replaced. It returns "" when path doesn't exist yet.
+func backup(path string) (string, error) {
+	name, err := writeTemp(path, nil)
+	if name != "" {
+		os.Remove(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Link(path, name); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return name, nil
+}
+
+// restore puts back what targets held before writeOutputs replaced them,
+// removing the ones that didn't exist.
+func restore(targets []outputTarget, backups []string) {
+	for i, target := range targets {
+		var err error
+		if backups[i] == "" {
+			err = os.Remove(target.path)
+		} else {
+			err = os.Rename(backups[i], target.path)
+			backups[i] = ""
+		}
+		if err != nil {
+			log.Printf("Error rolling back %s: %v\n", target.path, err)
+		}
+	}
+}
+
+// writeTemp writes content to a new temporary file in path's directory and
+// returns its name, which is set even when writing fails.
+func writeTemp(path string, content []byte) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), ".cotacao-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return file.Name(), err
+}
+
+// exitDiskFull is the exit status used when the quote file can't be written
+// because its disk is full, so monitoring can tell storage trouble apart.
+const exitDiskFull = 4
+
+// writeError describes a failed write of path, spelling out a full disk,
+// which stays detectable with errors.Is(err, syscall.ENOSPC).
+func writeError(what, path string, err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf(
+			"Error writing %s: the disk holding %s is full; free up space there or pass -out on another disk: %w",
+			what, path, err,
+		)
+	}
+	return fmt.Errorf("Error writing %s: %w", what, err)
+}
+
+// resolveOutputPath checks upfront that the quote file can be written. When
+// the default location isn't writable (e.g. a read-only working directory)
+// it falls back to fallbackDir; an explicitly requested path that isn't
+// writable is reported as an error instead.
+func resolveOutputPath(out string, explicit bool, fallbackDir string) (string, error) {
+	err := checkWritable(filepath.Dir(out))
+	if err == nil {
+		return out, nil
+	}
+	if explicit {
+		return "", fmt.Errorf("Output path %s is not writable: %v", out, err)
+	}
+
+	fallback := filepath.Join(fallbackDir, filepath.Base(out))
+	if fallbackErr := checkWritable(fallbackDir); fallbackErr != nil {
+		return "", fmt.Errorf(
+			"Neither %s nor fallback %s is writable: %v; %v",
+			out, fallback, err, fallbackErr,
+		)
+	}
+	log.Printf("%s is not writable (%v); writing to %s instead\n", out, err, fallback)
+	return fallback, nil
+}
+
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".cotacao-*")
+	if err != nil {
+		return err
+	}
+	name := file.Name()
+	file.Close()
+	return os.Remove(name)
+}
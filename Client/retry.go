@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// retryPolicy bounds how a fetch is retried: at most budget retries, each
+// starting within deadline of the first attempt, with every wait stretched
+// by a random share of up to jitter of itself so clients that failed
+// together don't all come back at once.
+type retryPolicy struct {
+	deadline time.Duration
+	budget   int
+	jitter   float64
+}
+
+// retryableError is a failure worth another attempt: the server couldn't
+// be reached or answered 5xx, or it answered 429/503 asking, through
+// Retry-After, to come back after wait.
+type retryableError struct {
+	wait time.Duration
+	err  error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// serverError is the retryable error for a failed response carrying body.
+func serverError(resp *http.Response, body []byte) error {
+	err := fmt.Errorf("Error response from server: %s", string(body))
+	if wait, ok := retryAfter(resp); ok {
+		return &retryableError{wait: wait, err: err}
+	}
+	if resp.StatusCode >= 500 {
+		return &retryableError{err: err}
+	}
+	return err
+}
+
+// retryAfter reads the Retry-After header of a 429 or 503 response, given in
+// seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// withBackoff runs fetch, and again after each retryable failure as policy
+// allows. The wait is the server's Retry-After when it gave one, and
+// otherwise doubles from retryBaseDelay up to retryMaxDelay; jitter only
+// ever adds to it, so the server's wish is still honored.
+func withBackoff(policy retryPolicy, fetch func() error) error {
+	stop := time.Now().Add(policy.deadline)
+	for attempt := 0; ; attempt++ {
+		err := fetch()
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt >= policy.budget {
+			return err
+		}
+
+		wait := retryable.wait
+		if wait == 0 {
+			wait = min(retryBaseDelay<<attempt, retryMaxDelay)
+		}
+		wait += time.Duration(rand.Float64() * policy.jitter * float64(wait))
+		if time.Now().Add(wait).After(stop) {
+			return err
+		}
+		log.Printf("%v; retrying in %v\n", err, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const stateFile = ".cotacao.state.json"
+
+// fetchState remembers the last response the server sent, so the next
+// request can be made conditional and a 304 still knows the current bid.
+type fetchState struct {
+	ETag string  `json:"etag"`
+	Bid  float64 `json:"bid"`
+
+	// Field is the -field Bid was read from.
+	Field string `json:"field,omitempty"`
+
+	// Source is the server's X-Quote-Source for the last response.
+	Source string `json:"source,omitempty"`
+
+	// CreateDate is the quote's create_date, kept with -max-age so a 304
+	// can still be checked.
+	CreateDate *time.Time `json:"create_date,omitempty"`
+}
+
+// loadFetchState returns an empty state when the file doesn't exist yet.
+func loadFetchState(path string) (*fetchState, error) {
+	state := &fetchState{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading state file: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Error decoding state file: %v", err)
+	}
+	return state, nil
+}
+
+func (s *fetchState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("Error encoding state file: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return writeError("state file", path, err)
+	}
+	return nil
+}
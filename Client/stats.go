@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logStats reports one request for -stats on stderr: its status, round
+// trip and body size, and the server's own share of the time when it sent
+// a Server-Timing header, so slowness can be told apart as network or
+// server. A nil resp is a request that got no response.
+func logStats(resp *http.Response, elapsed time.Duration, size int) {
+	elapsed = elapsed.Round(time.Microsecond)
+	if resp == nil {
+		log.Printf("No response after %v\n", elapsed)
+		return
+	}
+	line := fmt.Sprintf("HTTP %d in %v, %d bytes", resp.StatusCode, elapsed, size)
+	if server, ok := serverTiming(resp); ok {
+		line += fmt.Sprintf(" (server %v)", server.Round(time.Microsecond))
+	}
+	log.Printf("%s\n", line)
+}
+
+// serverTiming reads the first dur= of a Server-Timing header, given in
+// milliseconds.
+func serverTiming(resp *http.Response) (time.Duration, bool) {
+	_, rest, ok := strings.Cut(resp.Header.Get("Server-Timing"), "dur=")
+	if !ok {
+		return 0, false
+	}
+	raw, _, _ := strings.Cut(rest, ";")
+	raw, _, _ = strings.Cut(raw, ",")
+	ms, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms * float64(time.Millisecond)), true
+}
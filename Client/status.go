@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const timeoutStatus = 2 * time.Second
+
+// serverVersion and serverHealth are the parts of /version and /health the
+// status command reports.
+type serverVersion struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+}
+
+type serverHealth struct {
+	Status          string     `json:"status"`
+	Database        string     `json:"database"`
+	Freshness       string     `json:"freshness"`
+	QuoteAgeSeconds *int64     `json:"quote_age_seconds"`
+	LastError       string     `json:"last_error"`
+	LastErrorAt     *time.Time `json:"last_error_at"`
+}
+
+// runStatus prints a summary of the server's version and health, exiting
+// with status 1 unless the server reports itself healthy.
+func runStatus(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	conn := addConnectionFlags(flags)
+	flags.Parse(args)
+	conn.apply()
+
+	var health serverHealth
+	if err := getStatusJSON("/health", &health); err != nil {
+		log.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	version := "unknown"
+	var v serverVersion
+	if err := getStatusJSON("/version", &v); err != nil {
+		version += fmt.Sprintf(" (%v)", err)
+	} else {
+		version = v.Version
+		if v.Revision != "" {
+			version += " " + shortRevision(v.Revision)
+		}
+	}
+
+	printStatusLine("Server", serverURL)
+	printStatusLine("Version", version)
+	printStatusLine("Status", health.Status)
+	printStatusLine("Database", health.Database)
+	if health.LastError == "" {
+		printStatusLine("Last error", "none")
+	} else {
+		at := ""
+		if health.LastErrorAt != nil {
+			at = health.LastErrorAt.Local().Format(time.DateTime) + ": "
+		}
+		printStatusLine("Last error", at+health.LastError)
+	}
+	if health.QuoteAgeSeconds != nil {
+		age := time.Duration(*health.QuoteAgeSeconds) * time.Second
+		printStatusLine("Quote age", fmt.Sprintf("%s (%s)", age, health.Freshness))
+	} else {
+		printStatusLine("Quote age", "not checked (server has no HEALTH_MAX_AGE)")
+	}
+
+	if health.Status != "ok" {
+		os.Exit(1)
+	}
+}
+
+func printStatusLine(name, value string) {
+	fmt.Printf("%-11s %s\n", name+":", value)
+}
+
+func shortRevision(revision string) string {
+	if len(revision) > 12 {
+		return revision[:12]
+	}
+	return revision
+}
+
+// getStatusJSON decodes the JSON body of path into v. /health answers 503
+// with the same body when unhealthy, so any status with a JSON body counts.
+func getStatusJSON(path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutStatus)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("Error creating request: %v", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Error reading response body: %v", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Error decoding %s response (status %d): %v", path, resp.StatusCode, err)
+	}
+	return nil
+}
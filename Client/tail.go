@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	eventsPageSize = 1000
+	timeoutTail    = 2 * time.Second
+
+	tailMinBackoff = time.Second
+	tailMaxBackoff = 30 * time.Second
+)
+
+// quoteEvent is one entry of the server's /cotacao/events log.
+type quoteEvent struct {
+	ID         int64     `json:"id"`
+	QuoteID    int64     `json:"quote_id"`
+	Bid        float64   `json:"bid"`
+	Timestamp  int64     `json:"timestamp"`
+	CreateDate time.Time `json:"create_date"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	Source     string    `json:"source"`
+}
+
+// runTail follows the server's event log, printing each stored quote as it
+// shows up, until interrupted.
+func runTail(args []string) {
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	format := flags.String("format", "text", `output format: "text", or "json" for one event per line`)
+	interval := flags.Duration("interval", time.Second, "how often to ask the server for new events")
+	since := flags.Int64("since", -1, "print the events after this id; -1 only prints new ones")
+	conn := addConnectionFlags(flags)
+	flags.Parse(args)
+	conn.apply()
+
+	if *format != "text" && *format != "json" {
+		log.Printf("Invalid -format %q: expected text or json\n", *format)
+		os.Exit(2)
+	}
+	if *interval <= 0 {
+		log.Printf("Invalid -interval: must be positive\n")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tailEvents(ctx, *since, *interval, func(event quoteEvent) {
+		printEvent(event, *format)
+	})
+}
+
+// tailEvents reads the event log after since every interval, handing each
+// event to print, until ctx is done. A negative since first skips to the
+// end of the log. Failed requests are retried with exponential backoff.
+func tailEvents(ctx context.Context, since int64, interval time.Duration, print func(quoteEvent)) {
+	cursor, catchingUp := since, since < 0
+	if catchingUp {
+		cursor = 0
+	}
+
+	var backoff time.Duration
+	for {
+		events, err := getEvents(ctx, cursor)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			backoff = min(max(2*backoff, tailMinBackoff), tailMaxBackoff)
+			log.Printf("%v; retrying in %v\n", err, backoff)
+			if !sleepContext(ctx, backoff) {
+				return
+			}
+			continue
+		}
+		if backoff > 0 {
+			log.Printf("Reconnected to the server\n")
+			backoff = 0
+		}
+
+		for _, event := range events {
+			if !catchingUp {
+				print(event)
+			}
+			cursor = event.ID
+		}
+		if len(events) == eventsPageSize {
+			continue
+		}
+		catchingUp = false
+		if !sleepContext(ctx, interval) {
+			return
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func printEvent(event quoteEvent, format string) {
+	if format == "json" {
+		line, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Error encoding JSON line: %v\n", err)
+			return
+		}
+		fmt.Println(string(line))
+		return
+	}
+	fmt.Printf(
+		"%s %s %s\n",
+		event.FetchedAt.Local().Format(time.RFC3339),
+		strconv.FormatFloat(event.Bid, 'f', -1, 64),
+		event.Source,
+	)
+}
+
+func getEvents(ctx context.Context, since int64) ([]quoteEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeoutTail)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/cotacao/events?since=%d&limit=%d", serverURL, since, eventsPageSize)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request: %v", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response body: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Error response from server: %s", string(body))
+	}
+
+	var events []quoteEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, fmt.Errorf("Error decoding JSON: %v", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// connectionFlags are the flags every command takes for reaching the
+// server: where it is and, for https, which certificates to trust.
+type connectionFlags struct {
+	server   string
+	caCert   string
+	insecure bool
+}
+
+func addConnectionFlags(flags *flag.FlagSet) *connectionFlags {
+	c := &connectionFlags{}
+	flags.StringVar(&c.server, "server", defaultServerURL, "base URL of the server, http:// or https://")
+	flags.StringVar(&c.caCert, "cacert", "", "PEM file with the CA, or the server's own certificate, to trust instead of the system roots")
+	flags.BoolVar(&c.insecure, "insecure", false, "skip TLS certificate verification (for testing only)")
+	return c
+}
+
+// apply points serverURL and httpClient at what the flags ask for, exiting
+// on an invalid combination.
+func (c *connectionFlags) apply() {
+	tlsConfig, err := c.tlsConfig()
+	if err == nil {
+		err = c.setServer()
+	}
+	if err != nil {
+		log.Printf("%v\n", err)
+		os.Exit(2)
+	}
+	if c.insecure {
+		log.Printf("Warning: TLS certificate verification is disabled\n")
+	}
+	httpClient = newHTTPClient(tlsConfig)
+}
+
+func (c *connectionFlags) setServer() error {
+	u, err := url.Parse(c.server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid -server %q: expected an http:// or https:// URL", c.server)
+	}
+	serverURL = strings.TrimSuffix(c.server, "/")
+	return nil
+}
+
+// tlsConfig verifies the server against the system roots by default, or
+// only against -cacert, which pins a private CA or a self-signed server
+// certificate.
+func (c *connectionFlags) tlsConfig() (*tls.Config, error) {
+	switch {
+	case c.insecure && c.caCert != "":
+		return nil, errors.New("Only one of -cacert and -insecure can be given")
+	case c.insecure:
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	case c.caCert == "":
+		return nil, nil
+	}
+
+	pem, err := os.ReadFile(c.caCert)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading -cacert: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("Error reading -cacert: no PEM certificates in %s", c.caCert)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const timeoutWebhook = 5 * time.Second
+
+// exitWebhook is the exit status used when a quote couldn't be posted to
+// -webhook even after retrying, in watch mode too, so a failing
+// integration is noticed instead of silently missing rates.
+const exitWebhook = 6
+
+// webhookClient posts to -webhook. It is kept apart from httpClient, whose
+// -cacert and -insecure settings are meant for the quote server only.
+var webhookClient = &http.Client{}
+
+// webhookError is a quote that couldn't be delivered to -webhook.
+type webhookError struct {
+	err error
+}
+
+func (e *webhookError) Error() string { return e.err.Error() }
+func (e *webhookError) Unwrap() error { return e.err }
+
+func isWebhookError(err error) bool {
+	var hookErr *webhookError
+	return errors.As(err, &hookErr)
+}
+
+// checkWebhookURL rejects a -webhook that isn't an absolute http(s) URL.
+func checkWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Invalid -webhook %q: expected an http or https URL", raw)
+	}
+	return nil
+}
+
+// postWebhook POSTs bid to webhookURL as the same JSON object -ndjson
+// prints, retrying as policy allows when the webhook can't be reached or
+// answers 5xx, 429 or 503.
+func postWebhook(webhookURL string, policy retryPolicy, bid float64, source string) error {
+	body, err := json.Marshal(ndjsonLine{Timestamp: time.Now().UTC(), Bid: bid, Source: source})
+	if err != nil {
+		return fmt.Errorf("Error encoding webhook body: %v", err)
+	}
+	err = withBackoff(policy, func() error {
+		return sendWebhook(webhookURL, body)
+	})
+	if err != nil {
+		return &webhookError{err}
+	}
+	return nil
+}
+
+func sendWebhook(webhookURL string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutWebhook)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error creating webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return &retryableError{err: fmt.Errorf("Error posting to webhook: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	// Only a little of the answer is kept, to say why the webhook refused.
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("Error response from webhook: %s %s", resp.Status, strings.TrimSpace(string(reply)))
+	if wait, ok := retryAfter(resp); ok {
+		return &retryableError{wait: wait, err: err}
+	}
+	if resp.StatusCode >= 500 {
+		return &retryableError{err: err}
+	}
+	return err
+}
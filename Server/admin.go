@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// adminRefreshHandler forces an immediate fetch and store of the default
+// pair, going through the background poller when it runs.
+func adminRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var quote *Quote
+	var err error
+	if quotePoller != nil {
+		quote, err = quotePoller.refresh(r.Context())
+	} else {
+		quote, err = pollQuote(r.Context())
+	}
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to refresh quotation: %v", err),
+			upstreamStatus(err),
+		)
+		return
+	}
+
+	writeJSON(w, quote)
+}
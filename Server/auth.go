@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+var (
+	// adminAPIKey lets admin requests in through the X-API-Key header.
+	adminAPIKey string
+
+	// adminUser and adminPassword let admin requests in through HTTP basic
+	// auth, for tools that can't send custom headers. Both must be set.
+	adminUser     string
+	adminPassword string
+)
+
+// basicAuthEnabled reports whether basic credentials were configured.
+func basicAuthEnabled() bool {
+	return adminUser != "" && adminPassword != ""
+}
+
+// requireAdmin only lets requests through that carry the admin API key or,
+// when configured, the admin basic-auth credentials, all compared in
+// constant time. The admin endpoints are disabled while neither is set.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminAPIKey == "" && !basicAuthEnabled() {
+			http.Error(
+				w,
+				"Admin endpoints are disabled: set ADMIN_API_KEY or ADMIN_USER and ADMIN_PASSWORD",
+				http.StatusForbidden,
+			)
+			return
+		}
+		if validAPIKey(r) || validBasicAuth(r) {
+			next(w, r)
+			return
+		}
+
+		if basicAuthEnabled() {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+		}
+		switch {
+		case adminAPIKey == "":
+			http.Error(w, "Invalid or missing credentials", http.StatusUnauthorized)
+		case basicAuthEnabled():
+			http.Error(w, "Invalid or missing X-API-Key or credentials", http.StatusUnauthorized)
+		default:
+			http.Error(w, "Invalid or missing X-API-Key", http.StatusUnauthorized)
+		}
+	}
+}
+
+func validAPIKey(r *http.Request) bool {
+	if adminAPIKey == "" {
+		return false
+	}
+	key := r.Header.Get("X-API-Key")
+	return subtle.ConstantTimeCompare([]byte(key), []byte(adminAPIKey)) == 1
+}
+
+func validBasicAuth(r *http.Request) bool {
+	if !basicAuthEnabled() {
+		return false
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	// Both are compared even when the user is wrong, so timing doesn't
+	// tell which part failed.
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(adminUser))
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword))
+	return userOK&passwordOK == 1
+}
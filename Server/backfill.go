@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const maxBackfillSteps = 10000
+
+// BackfillResponse reports a backfill: how many rows it added, out of how
+// many steps it looked at, and how many empty steps it skipped for having
+// no stored quote before or after them.
+type BackfillResponse struct {
+	Added   int `json:"added"`
+	Steps   int `json:"steps"`
+	Skipped int `json:"skipped"`
+}
+
+func prepareBackfilledColumn() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return retrySchema(db, addBackfilledColumn)
+}
+
+// addBackfilledColumn adds the backfilled flag to a quotes table created
+// before it existed. Rows stored until then were all quoted, so they keep
+// the default 0.
+func addBackfilledColumn(db *sql.DB) error {
+	return inWriteTx(context.Background(), db, func(ctx context.Context, conn *sql.Conn) error {
+		var exists int
+		err := conn.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM pragma_table_xinfo('quotes') WHERE name = 'backfilled'",
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error inspecting quotes table: %w", err)
+		}
+		if exists > 0 {
+			return nil
+		}
+		if _, err := conn.ExecContext(ctx, "ALTER TABLE quotes ADD COLUMN backfilled INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return fmt.Errorf("error adding backfilled column: %w", err)
+		}
+		return nil
+	})
+}
+
+// gapQuotes returns a row for each step of [from, to) that holds no stored
+// quote but lies between stored quotes, as after an outage. The provider
+// only ever gives the latest quote, so there is no real quote for a past
+// step: the row carries forward the bid in effect at the step's start,
+// that of the newest quote stored before it, and is dated at the start.
+// Such rows are flagged backfilled, which keeps them out of OHLC. Steps
+// before the first stored quote or after the newest are left to skipped,
+// there being nothing to carry or nothing missing yet.
+func gapQuotes(db *sql.DB, from, to, step int64) (gaps []Quote, skipped int, err error) {
+	prev, err := quoteAt(db, time.Unix(from, 0))
+	if err != nil {
+		return nil, 0, err
+	}
+	// With nothing stored, newest is nil and so is prev: every step is
+	// skipped.
+	newest, err := latestStoredQuote(db)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutQuery)
+	defer cancel()
+	where, args := timestampRange(defaultPair, from, to)
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT bid, timestamp, create_date FROM quotes WHERE "+where+" ORDER BY timestamp, id",
+		args...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying quotes: %v", err)
+	}
+	defer rows.Close()
+	var stored []Quote
+	for rows.Next() {
+		var quote Quote
+		if err := rows.Scan(&quote.Bid, &quote.Timestamp, &quote.CreateDate); err != nil {
+			return nil, 0, fmt.Errorf("error reading quote: %v", err)
+		}
+		stored = append(stored, quote)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading quotes: %v", err)
+	}
+
+	next := 0
+	for start := from; start < to; start += step {
+		end := min(start+step, to)
+		filled := false
+		for ; next < len(stored) && stored[next].Timestamp < end; next++ {
+			filled = true
+			prev = &stored[next]
+		}
+		switch {
+		case filled:
+		case prev == nil || start >= newest.Timestamp:
+			skipped++
+		default:
+			gaps = append(gaps, Quote{
+				Bid:        prev.Bid,
+				Timestamp:  start,
+				CreateDate: time.Unix(start, 0).UTC(),
+				Backfilled: true,
+			})
+		}
+	}
+	return gaps, skipped, nil
+}
+
+// backfillHandler fills the gaps in the stored history of [from, to) at
+// step resolution, see gapQuotes, with from rounded down to a whole step.
+// Running it again adds nothing, since the steps it filled then hold a
+// quote.
+func backfillHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from: expected an RFC3339 time", http.StatusBadRequest)
+		return
+	}
+	to := clock()
+	if raw := query.Get("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			http.Error(w, "Invalid to: expected an RFC3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	step, err := time.ParseDuration(query.Get("step"))
+	if err != nil || step < time.Second || step%time.Second != 0 {
+		http.Error(w, "Invalid step: expected whole seconds, at least 1s, e.g. 5m", http.StatusBadRequest)
+		return
+	}
+	// Whole steps keep backfilled rows on round times, whatever from is.
+	from = from.Truncate(step)
+	if !from.Before(to) {
+		http.Error(w, "Invalid range: from must be before to", http.StatusBadRequest)
+		return
+	}
+	seconds := int64(step / time.Second)
+	steps := (to.Unix() - from.Unix() + seconds - 1) / seconds
+	if steps > maxBackfillSteps {
+		http.Error(
+			w,
+			fmt.Sprintf("Invalid range: %d steps, at most %d allowed; use a larger step", steps, maxBackfillSteps),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	db := openQuotesDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	gaps, skipped, err := gapQuotes(db, from.Unix(), to.Unix(), seconds)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to find gaps: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	added := 0
+	if len(gaps) > 0 {
+		if added, _, err = insertQuotes(db, gaps, "", eventSourceBackfill); err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("Failed to backfill quotes: %v", err),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		// Backfilled rows land inside the timeline.
+		recentQuotes.invalidate()
+	}
+	slog.Info("Backfilled quotes", "from", from, "to", to, "step", step, "added", added)
+	writeJSON(w, BackfillResponse{Added: added, Steps: int(steps), Skipped: skipped})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBackfilledRowsAreMarkedAndLeftOutOfOHLC fills a three-hour outage at
+// one-hour steps and checks the carried-forward rows are flagged, dated at
+// their own step, and open no candles, from the table or the ring.
+func TestBackfilledRowsAreMarkedAndLeftOutOfOHLC(t *testing.T) {
+	useTestDB(t)
+	start := time.Unix(1715900400, 0).UTC().Truncate(time.Hour)
+	end := start.Add(3 * time.Hour)
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	quoted := []Quote{
+		{Bid: 5.0, Timestamp: start.Unix(), CreateDate: start},
+		{Bid: 5.3, Timestamp: end.Unix(), CreateDate: end},
+	}
+	if _, _, err := insertQuotes(db, quoted, "", eventSourceImport); err != nil {
+		t.Fatal(err)
+	}
+
+	query := "from=" + start.Format(time.RFC3339) + "&to=" + end.Format(time.RFC3339) + "&step=1h"
+	rec := httptest.NewRecorder()
+	backfillHandler(rec, httptest.NewRequest("POST", "/admin/backfill?"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"added":2,"steps":3,"skipped":0}` {
+		t.Fatalf("body %s, want 2 rows added over 3 steps", got)
+	}
+
+	history, _, err := queryHistory(db, historyQuery{from: start, to: end.Add(time.Second), limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("%d rows in history, want 4", len(history))
+	}
+	for i, quote := range history {
+		wantBackfilled := i == 1 || i == 2
+		if quote.Backfilled != wantBackfilled {
+			t.Errorf("row %d backfilled = %v, want %v", i, quote.Backfilled, wantBackfilled)
+		}
+		if !quote.CreateDate.Equal(time.Unix(quote.Timestamp, 0)) {
+			t.Errorf("row %d create_date %v, want its own timestamp %d", i, quote.CreateDate, quote.Timestamp)
+		}
+	}
+
+	window := end.Add(time.Hour)
+	candles, err := queryCandles(db, start, window, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 2 || candles[0].T != start.Unix() || candles[1].T != end.Unix() {
+		t.Errorf("candles from the table %+v, want only the two quoted hours", candles)
+	}
+
+	recentQuotes.ensureLoaded(db)
+	candles, ok := recentCandles(start, window, time.Hour, false)
+	if !ok {
+		t.Fatal("ring did not cover the window")
+	}
+	if len(candles) != 2 || candles[0].T != start.Unix() || candles[1].T != end.Unix() {
+		t.Errorf("candles from the ring %+v, want only the two quoted hours", candles)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxRequestBody caps how many bytes of a request body the mutating
+// endpoints read.
+var maxRequestBody int64 = 10 << 20
+
+// limitRequestBody rejects bodies over maxRequestBody on every method but
+// GET, HEAD and OPTIONS: upfront with 413 when Content-Length says so, and
+// otherwise by failing the handler's read once the limit is reached.
+func limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > maxRequestBody {
+			http.Error(
+				w,
+				fmt.Sprintf("Request body exceeds %d bytes", maxRequestBody),
+				http.StatusRequestEntityTooLarge,
+			)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyErrorStatus is the status to answer a failed body read with: 413 when
+// the body went over maxRequestBody, 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// upstreamShare is the part of a request's remaining time the upstream
+// fetch may use; storing the quote gets whatever is left after it.
+const upstreamShare = 0.9
+
+// requestTimeout, when positive, is the deadline every request is served
+// within.
+var requestTimeout time.Duration
+
+// withRequestTimeout gives each request's context the requestTimeout
+// deadline the fetch and store stages split between them.
+func withRequestTimeout(next http.Handler) http.Handler {
+	if requestTimeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// stageContext bounds one stage of serving a request to limit. When ctx
+// carries a deadline the stage gets at most share of the time left until
+// it, so the server never works past what the caller is willing to wait.
+func stageContext(ctx context.Context, share float64, limit time.Duration) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		limit = min(limit, time.Duration(float64(time.Until(deadline))*share))
+	}
+	return context.WithTimeout(ctx, limit)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// setCacheControl lets shared caches keep a quote for CACHE_MAX_AGE, or with
+// force keeps every cache from storing it.
+func setCacheControl(w http.ResponseWriter, force bool) {
+	maxAge := settings().cacheMaxAge
+	switch {
+	case force:
+		w.Header().Set("Cache-Control", "no-store")
+	case maxAge <= 0:
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "time"
+
+// clock is read for the current time by everything that stores or compares
+// timestamps (quote stamps, OHLC windows, idempotency key expiry, health),
+// so tests can freeze or advance it. Latency measurements and deadlines keep
+// using time.Now, since they time real work.
+var clock = time.Now
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CompareResponse struct {
+	A     float64 `json:"a"`
+	B     float64 `json:"b"`
+	Ratio float64 `json:"ratio"`
+
+	pairA, pairB string
+}
+
+// MarshalJSON writes each bid with its pair's precision, like
+// ClientResponse, and the ratio with six decimals.
+func (r CompareResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		A     json.Number `json:"a"`
+		B     json.Number `json:"b"`
+		Ratio json.Number `json:"ratio"`
+	}{
+		A:     formatBid(r.A, r.pairA),
+		B:     formatBid(r.B, r.pairB),
+		Ratio: json.Number(strconv.FormatFloat(r.Ratio, 'f', 6, 64)),
+	})
+}
+
+// compareHandler fetches the current bids of pairs a and b in one batch and
+// answers both along with a/b. Nothing is stored.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	a := strings.ToUpper(query.Get("a"))
+	b := strings.ToUpper(query.Get("b"))
+	for _, param := range []struct{ name, pair string }{{"a", a}, {"b", b}} {
+		if !pairPattern.MatchString(param.pair) {
+			http.Error(
+				w,
+				fmt.Sprintf("Invalid currency pair %s=%q", param.name, param.pair),
+				http.StatusBadRequest,
+			)
+			return
+		}
+	}
+
+	quotes, err := fetchQuotes(r.Context(), []string{a, b})
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to get quotations: %v", err),
+			upstreamStatus(err),
+		)
+		return
+	}
+
+	if quotes[b].Bid == 0 {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to compare quotations: %s bid is zero", b),
+			http.StatusBadGateway,
+		)
+		return
+	}
+	writeJSON(w, CompareResponse{
+		A:     quotes[a].Bid,
+		B:     quotes[b].Bid,
+		Ratio: quotes[a].Bid / quotes[b].Bid,
+		pairA: a,
+		pairB: b,
+	})
+}
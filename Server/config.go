@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	// Bundled so UPSTREAM_TZ resolves on hosts without a zoneinfo database.
+	_ "time/tzdata"
+)
+
+// Store modes: storeOnChange only inserts a quote whose upstream timestamp
+// differs from the last stored one; storeAlways inserts every fetch,
+// stamped with the server's fetch time.
+const (
+	storeOnChange = "on_change"
+	storeAlways   = "always"
+)
+
+var (
+	// maxUpstreamBody caps how many bytes of an upstream response are read.
+	maxUpstreamBody int64 = 1 << 20
+
+	// upstreamRetries is how many extra attempts a retryable upstream
+	// failure gets. Retrying is off by default.
+	upstreamRetries = 0
+
+	storeMode = storeOnChange
+
+	// minChange, when positive, is how far the bid must move from the
+	// newest stored one before a quote is stored: an absolute amount or,
+	// with minChangePercent, a percentage of that bid.
+	minChange        float64
+	minChangePercent bool
+
+	// upstreamLocation is the zone the provider's create_date is written in.
+	upstreamLocation *time.Location
+
+	// walEnabled switches the database to write-ahead logging at startup.
+	walEnabled bool
+
+	// pollDrainTimeout bounds how long shutdown waits for a poll in flight.
+	pollDrainTimeout = 5 * time.Second
+
+	// dbWaitTimeout is how long startup keeps retrying a database that
+	// doesn't answer yet. Zero tries once.
+	dbWaitTimeout time.Duration
+)
+
+// loadConfig applies the environment variables that tune the server.
+func loadConfig() error {
+	var err error
+	if pairURLs, err = parsePairURLs(getenv("PAIR_PROVIDERS")); err != nil {
+		return fmt.Errorf("invalid PAIR_PROVIDERS: %v", err)
+	}
+	if multiPartial, err = envBool("MULTI_PAIR_PARTIAL", true); err != nil {
+		return err
+	}
+	if raw := getenv("BID_PRECISION"); raw != "" {
+		bidPrecision, err = strconv.Atoi(raw)
+		if err != nil || bidPrecision < 0 || bidPrecision > maxPrecision {
+			return fmt.Errorf("invalid BID_PRECISION %q: expected 0 to %d", raw, maxPrecision)
+		}
+	}
+	if pairPrecisions, err = parsePairPrecisions(getenv("PAIR_PRECISION")); err != nil {
+		return fmt.Errorf("invalid PAIR_PRECISION: %v", err)
+	}
+	if name := getenv("RESPONSE_BID_FIELD"); name != "" {
+		if err := checkResponseBidField(name); err != nil {
+			return err
+		}
+		responseBidField = name
+	}
+	if maxUpstreamBody, err = envInt64("UPSTREAM_MAX_BODY", maxUpstreamBody); err != nil {
+		return err
+	}
+	if maxUpstreamBody <= 0 {
+		return fmt.Errorf("invalid UPSTREAM_MAX_BODY: must be positive")
+	}
+	if maxRequestBody, err = envInt64("MAX_REQUEST_BODY", maxRequestBody); err != nil {
+		return err
+	}
+	if maxRequestBody <= 0 {
+		return fmt.Errorf("invalid MAX_REQUEST_BODY: must be positive")
+	}
+	tz := getenv("UPSTREAM_TZ")
+	if tz == "" {
+		tz = "America/Sao_Paulo"
+	}
+	if upstreamLocation, err = time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("invalid UPSTREAM_TZ: %v", err)
+	}
+	retries, err := envInt64("UPSTREAM_RETRIES", int64(upstreamRetries))
+	if err != nil {
+		return err
+	}
+	if retries < 0 || retries > 5 {
+		return fmt.Errorf("invalid UPSTREAM_RETRIES: expected 0 to 5")
+	}
+	upstreamRetries = int(retries)
+	redirects, err := envInt64("UPSTREAM_MAX_REDIRECTS", int64(upstreamMaxRedirects))
+	if err != nil {
+		return err
+	}
+	if redirects < 0 || redirects > 10 {
+		return fmt.Errorf("invalid UPSTREAM_MAX_REDIRECTS: expected 0 to 10")
+	}
+	upstreamMaxRedirects = int(redirects)
+	if upstreamDialTimeout, err = envDuration("UPSTREAM_DIAL_TIMEOUT", upstreamDialTimeout); err != nil {
+		return err
+	}
+	if upstreamTLSTimeout, err = envDuration("UPSTREAM_TLS_TIMEOUT", upstreamTLSTimeout); err != nil {
+		return err
+	}
+	if upstreamDialTimeout <= 0 || upstreamTLSTimeout <= 0 {
+		return fmt.Errorf("invalid UPSTREAM_DIAL_TIMEOUT or UPSTREAM_TLS_TIMEOUT: must be positive")
+	}
+	upstreamClient = newUpstreamClient()
+	concurrency, err := envInt64("UPSTREAM_CONCURRENCY", int64(upstreamConcurrency))
+	if err != nil {
+		return err
+	}
+	if concurrency < 0 || concurrency > 100 {
+		return fmt.Errorf("invalid UPSTREAM_CONCURRENCY: expected 0 to 100")
+	}
+	upstreamConcurrency = int(concurrency)
+	if upstreamConcurrency > 0 {
+		upstreamSlots = make(chan struct{}, upstreamConcurrency)
+	}
+	if upstreamSameHost, err = envBool("UPSTREAM_SAME_HOST_REDIRECTS", upstreamSameHost); err != nil {
+		return err
+	}
+
+	reloadable, err := loadReloadable()
+	if err != nil {
+		return err
+	}
+	if reloadable.upstreamRate > 0 {
+		setUpstreamQuota(reloadable.upstreamRate, reloadable.upstreamBurst)
+	}
+	applySettings(reloadable)
+	if spec := getenv("POLL_CRON"); spec != "" {
+		if reloadable.pollInterval > 0 {
+			return fmt.Errorf("only one of POLL_INTERVAL and POLL_CRON can be set")
+		}
+		if pollSchedule, err = cron.ParseStandard(spec); err != nil {
+			return fmt.Errorf("invalid POLL_CRON %q: %v", spec, err)
+		}
+	}
+	if pollDrainTimeout, err = envDuration("POLL_DRAIN_TIMEOUT", pollDrainTimeout); err != nil {
+		return err
+	}
+	if longPollTimeout, err = envDuration("LONG_POLL_TIMEOUT", longPollTimeout); err != nil {
+		return err
+	}
+	if longPollTimeout <= 0 {
+		return fmt.Errorf("invalid LONG_POLL_TIMEOUT: must be positive")
+	}
+	if adminAPIKey, err = envSecret("ADMIN_API_KEY"); err != nil {
+		return err
+	}
+	adminUser = getenv("ADMIN_USER")
+	if adminPassword, err = envSecret("ADMIN_PASSWORD"); err != nil {
+		return err
+	}
+	if (adminUser == "") != (adminPassword == "") {
+		return fmt.Errorf("ADMIN_USER and ADMIN_PASSWORD must be set together")
+	}
+	if upstreamAPIKey, err = envSecret("UPSTREAM_API_KEY"); err != nil {
+		return err
+	}
+	corsOrigins = parseCORSOrigins(getenv("CORS_ALLOWED_ORIGINS"))
+	if getenv("TRUST_PROXY_HEADERS") != "" {
+		return fmt.Errorf("TRUST_PROXY_HEADERS is no longer supported: list the proxies in TRUSTED_PROXIES instead")
+	}
+	if trustedProxies, err = parseTrustedProxies(getenv("TRUSTED_PROXIES")); err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	if walEnabled, err = envBool("DB_WAL", false); err != nil {
+		return err
+	}
+	recent, err := envInt64("RECENT_QUOTES", defaultRecentQuotes)
+	if err != nil {
+		return err
+	}
+	if recent < 0 || recent > 1_000_000 {
+		return fmt.Errorf("invalid RECENT_QUOTES: expected 0 to 1000000")
+	}
+	recentQuotes = newQuoteRing(int(recent))
+	if pprofEnabled, err = envBool("ENABLE_PPROF", false); err != nil {
+		return err
+	}
+	if addr := getenv("PPROF_ADDR"); addr != "" {
+		pprofAddr = addr
+	}
+	if requestTimeout, err = envDuration("REQUEST_TIMEOUT", 0); err != nil {
+		return err
+	}
+	if requestTimeout < 0 {
+		return fmt.Errorf("invalid REQUEST_TIMEOUT: must not be negative")
+	}
+	if dayIndexEnabled, err = envBool("DB_DAY_INDEX", false); err != nil {
+		return err
+	}
+	if warmUpstream, err = envBool("WARM_UPSTREAM", false); err != nil {
+		return err
+	}
+	days, err := envInt64("RETENTION_DAYS", 0)
+	if err != nil {
+		return err
+	}
+	if days < 0 || days > 36500 {
+		return fmt.Errorf("invalid RETENTION_DAYS: expected 0 to 36500")
+	}
+	retentionDays = int(days)
+	if persist, err = envBool("PERSIST", true); err != nil {
+		return err
+	}
+	if !persist && (retentionDays > 0 || dayIndexEnabled) {
+		return fmt.Errorf("invalid PERSIST=false: RETENTION_DAYS and DB_DAY_INDEX need the database")
+	}
+	readDSN = getenv("DB_READ_DSN")
+	if !persist && readDSN != "" {
+		return fmt.Errorf("invalid PERSIST=false: DB_READ_DSN needs the database")
+	}
+	if recoverCorrupt, err = envBool("DB_RECOVER_CORRUPT", false); err != nil {
+		return err
+	}
+	if dbWaitTimeout, err = envDuration("DB_WAIT_TIMEOUT", 0); err != nil {
+		return err
+	}
+	if dbWaitTimeout < 0 {
+		return fmt.Errorf("invalid DB_WAIT_TIMEOUT: must not be negative")
+	}
+
+	switch mode := getenv("STORE_MODE"); mode {
+	case "":
+	case storeOnChange, storeAlways:
+		storeMode = mode
+	default:
+		return fmt.Errorf("invalid STORE_MODE %q: expected %s or %s", mode, storeOnChange, storeAlways)
+	}
+	if raw := getenv("MIN_CHANGE"); raw != "" {
+		value, percent := strings.CutSuffix(strings.TrimSpace(raw), "%")
+		minChange, err = strconv.ParseFloat(value, 64)
+		if err != nil || !(minChange >= 0) || math.IsInf(minChange, 1) {
+			return fmt.Errorf("invalid MIN_CHANGE %q: expected a non-negative amount, or a percentage like 0.1%%", raw)
+		}
+		minChangePercent = percent
+	}
+	return nil
+}
+
+// envInt64 returns the integer value of the named variable, or def when it
+// is unset.
+func envInt64(name string, def int64) (int64, error) {
+	raw := getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return v, nil
+}
+
+// envDuration returns the duration value of the named variable, or def when
+// it is unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	raw := getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return v, nil
+}
+
+// envSecret returns the named secret, read from the file named by
+// name+"_FILE" (the docker secrets pattern) when that is set, so the value
+// stays out of the process environment; otherwise from the variable itself.
+func envSecret(name string) (string, error) {
+	path := getenv(name + "_FILE")
+	if path == "" {
+		return getenv(name), nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s_FILE: %v", name, err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// envBool returns the boolean value of the named variable, or def when it is
+// unset.
+func envBool(name string, def bool) (bool, error) {
+	raw := getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return v, nil
+}
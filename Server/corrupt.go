@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/glebarez/go-sqlite"
+)
+
+const (
+	sqliteCorrupt = 11
+	sqliteNotADB  = 26
+)
+
+// recoverCorrupt makes startup move a corrupt database aside and start over
+// with an empty one instead of refusing to start.
+var recoverCorrupt bool
+
+var errDatabaseCorrupt = errors.New("database is corrupt")
+
+func isCorruptError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqliteCorrupt || code == sqliteNotADB
+}
+
+// checkIntegrity runs SQLite's quick check over the whole file, so
+// corruption is found at startup rather than by the query that first
+// touches a bad page.
+func checkIntegrity() error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	err = db.QueryRowContext(context.Background(), "PRAGMA quick_check(1)").Scan(&result)
+	switch {
+	case isCorruptError(err):
+		return fmt.Errorf("%w: %v", errDatabaseCorrupt, err)
+	case err != nil:
+		return fmt.Errorf("error checking database integrity: %v", err)
+	case result != "ok":
+		return fmt.Errorf("%w: %s", errDatabaseCorrupt, result)
+	}
+	return nil
+}
+
+// recoverIfCorrupt fails with instructions when dbPath is corrupt or, with
+// recoverCorrupt, renames it and its WAL files aside so openDB creates a
+// fresh schema.
+func recoverIfCorrupt() error {
+	err := checkIntegrity()
+	if !errors.Is(err, errDatabaseCorrupt) {
+		return err
+	}
+	if !recoverCorrupt {
+		return fmt.Errorf(
+			"%v; restore %s from a backup, or set DB_RECOVER_CORRUPT=true to move it aside and start with an empty database",
+			err, dbPath,
+		)
+	}
+
+	aside := fmt.Sprintf("%s.corrupt-%s", dbPath, clock().UTC().Format("20060102T150405Z"))
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(dbPath+suffix, aside+suffix)
+		if err != nil && !(suffix != "" && errors.Is(err, os.ErrNotExist)) {
+			return fmt.Errorf("error moving corrupt database aside: %v", err)
+		}
+	}
+	slog.Warn(
+		"Database is corrupt; moved it aside and starting with an empty one",
+		"path", dbPath,
+		"moved_to", aside,
+		"error", err,
+	)
+	return nil
+}
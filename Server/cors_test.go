@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func corsTestHandler(t *testing.T, origins string) http.Handler {
+	t.Helper()
+	previous := corsOrigins
+	corsOrigins = parseCORSOrigins(origins)
+	t.Cleanup(func() { corsOrigins = previous })
+	return cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+}
+
+func preflight(handler http.Handler, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("OPTIONS", "/cotacao", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := corsTestHandler(t, "https://app.example, https://other.example/")
+
+	rec := preflight(handler, "https://app.example")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("allowed preflight: status %d, want 204", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+		t.Errorf("Access-Control-Allow-Origin %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") == "" || rec.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Error("preflight answered without Access-Control-Allow-Methods and -Headers")
+	}
+
+	rec = preflight(handler, "https://evil.example")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("disallowed preflight: status %d, want 403", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed preflight got Access-Control-Allow-Origin %q", got)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	handler := corsTestHandler(t, "https://app.example")
+
+	for origin, allowed := range map[string]bool{"https://app.example": true, "https://evil.example": false} {
+		req := httptest.NewRequest("GET", "/cotacao", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", origin, rec.Code)
+		}
+		got := rec.Header().Get("Access-Control-Allow-Origin")
+		if allowed && got != origin {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want the origin", origin, got)
+		}
+		if !allowed && got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want none", origin, got)
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	handler := corsTestHandler(t, "*")
+
+	if got := preflight(handler, "https://anywhere.example").Header().Get("Access-Control-Allow-Origin"); got != "https://anywhere.example" {
+		t.Errorf("Access-Control-Allow-Origin %q with *, want the origin", got)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+//go:embed dashboard.html
+var dashboardFiles embed.FS
+
+var dashboardPage = template.Must(template.ParseFS(dashboardFiles, "dashboard.html"))
+
+// dashboardHandler serves a self-contained page showing the current quote
+// and a chart of the last day's history, both fetched from this server.
+// The page is told responseBidField, so it finds the bid in /cotacao
+// answers under RESPONSE_BID_FIELD.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	var page bytes.Buffer
+	if err := dashboardPage.Execute(&page, struct{ BidField string }{responseBidField}); err != nil {
+		slog.Error("Could not render the dashboard", "error", err)
+		http.Error(w, "Failed to render the dashboard", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardReadsResponseBidField(t *testing.T) {
+	previous := responseBidField
+	responseBidField = "rate"
+	t.Cleanup(func() { responseBidField = previous })
+
+	rec := httptest.NewRecorder()
+	dashboardHandler(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type %q, want HTML", got)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `const bidField = "rate";`) {
+		t.Error("page doesn't read the bid under RESPONSE_BID_FIELD")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	secondsPerDay = 24 * 60 * 60
+
+	retentionInterval = time.Hour
+	timeoutPrune      = 30 * time.Second
+)
+
+var (
+	// dayIndexEnabled adds an indexed day column (timestamp / 86400) to the
+	// quotes table, which range queries and pruning then go through.
+	dayIndexEnabled bool
+
+	// retentionDays, when positive, is how many whole UTC days of quotes
+	// are kept besides the current one.
+	retentionDays int
+)
+
+func prepareDayIndex() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return ensureDayIndex(db)
+}
+
+// ensureDayIndex adds the day column as a virtual generated column, so
+// existing rows and every insert get it without being rewritten, and
+// indexes it. Both happen under the write lock, so instances starting
+// together add the column once.
+func ensureDayIndex(db *sql.DB) error {
+	return retrySchema(db, addDayIndex)
+}
+
+func addDayIndex(db *sql.DB) error {
+	return inWriteTx(context.Background(), db, func(ctx context.Context, conn *sql.Conn) error {
+		// table_info leaves generated columns out; table_xinfo lists them.
+		var exists int
+		err := conn.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM pragma_table_xinfo('quotes') WHERE name = 'day'",
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error inspecting quotes table: %w", err)
+		}
+		if exists == 0 {
+			_, err = conn.ExecContext(ctx, fmt.Sprintf(
+				"ALTER TABLE quotes ADD COLUMN day INTEGER GENERATED ALWAYS AS (timestamp / %d) VIRTUAL",
+				secondsPerDay,
+			))
+			if err != nil {
+				return fmt.Errorf("error adding day column: %w", err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS quotes_day ON quotes (day)"); err != nil {
+			return fmt.Errorf("error creating day index: %w", err)
+		}
+		return nil
+	})
+}
+
+// timestampRange is the condition selecting pair's quotes with from <=
+// timestamp < to. With the day index it also bounds the day, so SQLite
+// reads only those days.
+func timestampRange(pair string, from, to int64) (string, []any) {
+	if !dayIndexEnabled {
+		return "pair = ? AND timestamp >= ? AND timestamp < ?", []any{pair, from, to}
+	}
+	return "pair = ? AND day BETWEEN ? AND ? AND timestamp >= ? AND timestamp < ?",
+		[]any{pair, from / secondsPerDay, (to - 1) / secondsPerDay, from, to}
+}
+
+// pruneQuotes deletes the quotes of the days past retention. With the day
+// index whole days are found through it instead of by scanning the table.
+func pruneQuotes(db *sql.DB) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutPrune)
+	defer cancel()
+
+	cutoff := clock().UTC().Truncate(24*time.Hour).AddDate(0, 0, -retentionDays).Unix()
+	query, arg := "DELETE FROM quotes WHERE timestamp < ?", cutoff
+	if dayIndexEnabled {
+		query, arg = "DELETE FROM quotes WHERE day < ?", cutoff/secondsPerDay
+	}
+
+	result, err := db.ExecContext(ctx, query, arg)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning quotes: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// runRetention prunes expired quotes at startup and then every
+// retentionInterval until ctx is done.
+func runRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		prune()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func prune() {
+	db, err := openDB()
+	if err != nil {
+		slog.Error("Retention failed to open database", "error", err)
+		return
+	}
+	defer db.Close()
+
+	deleted, err := pruneQuotes(db)
+	if err != nil {
+		slog.Error("Retention failed", "error", err)
+		return
+	}
+	if deleted > 0 {
+		recentQuotes.invalidate()
+		slog.Info("Pruned expired quotes", "deleted", deleted, "retention_days", retentionDays)
+	}
+}
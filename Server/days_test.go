@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// insertEvery stores a quote every step over [from, to) in one transaction.
+func insertEvery(b *testing.B, db *sql.DB, from, to time.Time, step time.Duration) {
+	b.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO quotes (pair, bid, timestamp, create_date) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stmt.Close()
+	for at := from; at.Before(to); at = at.Add(step) {
+		if _, err := stmt.Exec(defaultPair, 5.12, at.Unix(), at.UTC()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkPruneMonth prunes a month of quotes stored every five minutes,
+// past RETENTION_DAYS=30, from a table that also holds the month kept,
+// by scanning timestamps and through the day index.
+func BenchmarkPruneMonth(b *testing.B) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cutoff := now.Truncate(24*time.Hour).AddDate(0, 0, -30)
+	const step = 5 * time.Minute
+
+	for name, indexed := range map[string]bool{"scan": false, "day-index": true} {
+		b.Run(name, func(b *testing.B) {
+			useTestDB(b)
+			withClock(b, now)
+			previousIndex, previousRetention := dayIndexEnabled, retentionDays
+			dayIndexEnabled, retentionDays = indexed, 30
+			b.Cleanup(func() { dayIndexEnabled, retentionDays = previousIndex, previousRetention })
+
+			db, err := openDB()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			if indexed {
+				if err := ensureDayIndex(db); err != nil {
+					b.Fatal(err)
+				}
+			}
+			insertEvery(b, db, cutoff, now, step)
+
+			b.ResetTimer()
+			for range b.N {
+				b.StopTimer()
+				insertEvery(b, db, cutoff.AddDate(0, 0, -30), cutoff, step)
+				b.StartTimer()
+
+				deleted, err := pruneQuotes(db)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if want := int64(30 * 24 * time.Hour / step); deleted != want {
+					b.Fatalf("pruned %d quotes, want %d", deleted, want)
+				}
+			}
+		})
+	}
+}
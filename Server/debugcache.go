@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CacheDump is what /debug/cache reports: every in-memory copy of quote
+// state, each read under its own lock, with ages counted from the quotes'
+// upstream timestamps.
+type CacheDump struct {
+	// LastStored is lastStored, the newest stored timestamp per pair.
+	LastStored []CachedTimestamp `json:"last_stored"`
+	// LatestPolled is the quote /cotacao/wait hands out.
+	LatestPolled *CachedQuote `json:"latest_polled"`
+	Recent       RecentDump   `json:"recent_quotes"`
+}
+
+type CachedTimestamp struct {
+	Pair       string `json:"pair"`
+	Timestamp  int64  `json:"timestamp"`
+	AgeSeconds int64  `json:"age_seconds"`
+}
+
+type CachedQuote struct {
+	Pair       string  `json:"pair"`
+	Bid        float64 `json:"bid"`
+	Timestamp  int64   `json:"timestamp"`
+	AgeSeconds int64   `json:"age_seconds"`
+}
+
+// RecentDump summarizes recentQuotes rather than listing up to
+// RECENT_QUOTES rows.
+type RecentDump struct {
+	Loaded    bool         `json:"loaded"`
+	Count     int          `json:"count"`
+	Capacity  int          `json:"capacity"`
+	Truncated bool         `json:"truncated"`
+	Oldest    *CachedQuote `json:"oldest,omitempty"`
+	Newest    *CachedQuote `json:"newest,omitempty"`
+}
+
+func cachedQuote(pair string, quote Quote, now int64) *CachedQuote {
+	return &CachedQuote{
+		Pair:       pair,
+		Bid:        quote.Bid,
+		Timestamp:  quote.Timestamp,
+		AgeSeconds: max(now-quote.Timestamp, 0),
+	}
+}
+
+// current returns the newest published quote, or nil before the first.
+func (n *quoteNotifier) current() *Quote {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.latest
+}
+
+func (q *quoteRing) dump(now int64) RecentDump {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dump := RecentDump{Loaded: q.loaded, Count: q.count, Capacity: len(q.quotes), Truncated: q.truncated}
+	if q.loaded && q.count > 0 {
+		dump.Oldest = cachedQuote(defaultPair, q.at(0).Quote, now)
+		dump.Newest = cachedQuote(defaultPair, q.at(q.count-1).Quote, now)
+	}
+	return dump
+}
+
+func debugCacheHandler(w http.ResponseWriter, r *http.Request) {
+	now := clock().Unix()
+	dump := CacheDump{LastStored: []CachedTimestamp{}, Recent: recentQuotes.dump(now)}
+	lastStored.Range(func(pair, timestamp any) bool {
+		dump.LastStored = append(dump.LastStored, CachedTimestamp{
+			Pair:       pair.(string),
+			Timestamp:  timestamp.(int64),
+			AgeSeconds: max(now-timestamp.(int64), 0),
+		})
+		return true
+	})
+	slices.SortFunc(dump.LastStored, func(a, b CachedTimestamp) int {
+		return strings.Compare(a.Pair, b.Pair)
+	})
+	if quote := quoteUpdates.current(); quote != nil {
+		dump.LatestPolled = cachedQuote(defaultPair, *quote, now)
+	}
+	writeJSON(w, dump)
+}
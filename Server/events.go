@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+
+	eventSourceImport   = "import"
+	eventSourceBackfill = "backfill"
+)
+
+// QuoteEvent records one quote being stored: the quote itself, when it was
+// stored and where it came from, the provider URL, eventSourceImport or
+// eventSourceBackfill.
+// Events are append-only and their ids are never reused, so a consumer can
+// resume from the last id it saw.
+type QuoteEvent struct {
+	ID         int64     `json:"id"`
+	QuoteID    int64     `json:"quote_id"`
+	Bid        float64   `json:"bid"`
+	Timestamp  int64     `json:"timestamp"`
+	CreateDate time.Time `json:"create_date"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	Source     string    `json:"source"`
+}
+
+func ensureQuoteEventsExist(ctx context.Context, db execer) error {
+	createTableSQL := `
+    CREATE TABLE IF NOT EXISTS quote_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        quote_id INTEGER NOT NULL,
+        bid DECIMAL(10, 4) NOT NULL,
+        timestamp BIGINT NOT NULL,
+        create_date DATETIME NOT NULL,
+        fetched_at BIGINT NOT NULL,
+        source TEXT NOT NULL
+    );`
+
+	if _, err := db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating quote_events table: %w", err)
+	}
+	return nil
+}
+
+// appendEvent records that quote was stored under quoteID, in the same
+// transaction as the insert.
+func appendEvent(ctx context.Context, db execer, quoteID int64, quote *Quote, source string) error {
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO quote_events (quote_id, bid, timestamp, create_date, fetched_at, source)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		quoteID,
+		quote.Bid,
+		quote.Timestamp,
+		quote.CreateDate,
+		clock().Unix(),
+		source,
+	)
+	return err
+}
+
+// queryEvents returns up to limit events with an id above since, oldest
+// first.
+func queryEvents(db *sql.DB, since int64, limit int) ([]QuoteEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutQuery)
+	defer cancel()
+
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT id, quote_id, bid, timestamp, create_date, fetched_at, source
+        FROM quote_events WHERE id > ? ORDER BY id LIMIT ?`,
+		since,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []QuoteEvent{}
+	for rows.Next() {
+		var event QuoteEvent
+		var fetchedAt int64
+		err := rows.Scan(
+			&event.ID,
+			&event.QuoteID,
+			&event.Bid,
+			&event.Timestamp,
+			&event.CreateDate,
+			&fetchedAt,
+			&event.Source,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error reading event: %v", err)
+		}
+		event.FetchedAt = time.Unix(fetchedAt, 0).UTC()
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading events: %v", err)
+	}
+	return events, nil
+}
+
+func getEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since int64
+	if raw := query.Get("since"); raw != "" {
+		var err error
+		since, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || since < 0 {
+			http.Error(w, "Invalid since: expected a non-negative event id", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultEventsLimit
+	if raw := query.Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxEventsLimit {
+			http.Error(
+				w,
+				fmt.Sprintf("Invalid limit: expected 1 to %d", maxEventsLimit),
+				http.StatusBadRequest,
+			)
+			return
+		}
+	}
+
+	db := openQuotesDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	events, err := queryEvents(db, since, limit)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to query events: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	writeJSON(w, events)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// exportHandler streams every stored quote, oldest first, as a JSON array
+// (format=json, the default) or one JSON object per line (format=ndjson).
+// format=sqlite instead serves a consistent copy of the whole database.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json", "ndjson", "sqlite":
+	default:
+		http.Error(w, `Invalid format: expected "json", "ndjson" or "sqlite"`, http.StatusBadRequest)
+		return
+	}
+
+	db := openQuotesDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	if format == "sqlite" {
+		exportSQLite(r.Context(), w, db)
+		return
+	}
+	exportQuotes(r.Context(), w, db, format == "ndjson")
+}
+
+// exportQuotes writes the rows as they are read, so the export never has
+// to fit in memory. Once the first row is out the status can't change any
+// more: a failure after that is logged and the body is cut short, which
+// leaves a JSON array unterminated.
+func exportQuotes(ctx context.Context, w http.ResponseWriter, db *sql.DB, ndjson bool) {
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT bid, timestamp, create_date, var_bid, pct_change, high, low, backfilled
+        FROM quotes WHERE pair = ? ORDER BY timestamp, id`,
+		defaultPair,
+	)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to export quotes: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	defer rows.Close()
+
+	name, separator := "quotes.json", []byte(",")
+	w.Header().Set("Content-Type", "application/json")
+	if ndjson {
+		name, separator = "quotes.ndjson", nil
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if !ndjson {
+		io.WriteString(w, "[")
+	}
+
+	encoder := json.NewEncoder(w)
+	exported := 0
+	for rows.Next() {
+		var quote Quote
+		err := rows.Scan(
+			&quote.Bid,
+			&quote.Timestamp,
+			&quote.CreateDate,
+			&quote.VarBid,
+			&quote.PctChange,
+			&quote.High,
+			&quote.Low,
+			&quote.Backfilled,
+		)
+		if err != nil {
+			slog.Error("Export failed", "error", err, "exported", exported)
+			return
+		}
+		if exported > 0 {
+			w.Write(separator)
+		}
+		if err := encoder.Encode(quote); err != nil {
+			slog.Error("Export failed", "error", err, "exported", exported)
+			return
+		}
+		exported++
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Export failed", "error", err, "exported", exported)
+		return
+	}
+	if !ndjson {
+		io.WriteString(w, "]\n")
+	}
+	slog.Info("Exported quotes", "count", exported)
+}
+
+// exportSQLite snapshots the database with VACUUM INTO, which reads it in
+// a single transaction, and streams the copy.
+func exportSQLite(ctx context.Context, w http.ResponseWriter, db *sql.DB) {
+	dir, err := os.MkdirTemp("", "quotes-export-")
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to export database: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dollarQuotation.db")
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to export database: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to export database: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="dollarQuotation.db"`)
+	if _, err := io.Copy(w, file); err != nil {
+		slog.Error("Export failed", "error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// oldestQuote returns pair's oldest stored quote, or nil when none is
+// stored. It is latestQuotes the other way round: ordered by timestamp on
+// the pair's index, so an import of older rows counts as older even though
+// it was inserted later.
+func oldestQuote(ctx context.Context, db *sql.DB, pair string) (*Quote, error) {
+	var quote Quote
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT bid, timestamp, create_date, var_bid, pct_change, high, low
+        FROM quotes WHERE pair = ? ORDER BY timestamp, id LIMIT 1`,
+		pair,
+	).Scan(
+		&quote.Bid,
+		&quote.Timestamp,
+		&quote.CreateDate,
+		&quote.VarBid,
+		&quote.PctChange,
+		&quote.High,
+		&quote.Low,
+	)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("error querying oldest quote: %v", err)
+	}
+	return &quote, nil
+}
+
+func getFirstQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	db := openReadDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeoutQuery)
+	defer cancel()
+	quote, err := oldestQuote(ctx, db, defaultPair)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to query quote: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if quote == nil {
+		http.Error(w, "No quote stored yet", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, quote)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// lastFailure remembers the most recent upstream or database error in the
+// fetch-and-store path, so /health can explain why quotes stopped flowing.
+// The next successful fetch and store clears it.
+var lastFailure struct {
+	sync.Mutex
+	message string
+	at      time.Time
+}
+
+func recordFailure(err error) {
+	lastFailure.Lock()
+	defer lastFailure.Unlock()
+	lastFailure.message = err.Error()
+	lastFailure.at = clock()
+}
+
+func recordSuccess() {
+	lastFailure.Lock()
+	defer lastFailure.Unlock()
+	lastFailure.message = ""
+	lastFailure.at = time.Time{}
+}
+
+type HealthResponse struct {
+	Status          string     `json:"status"`
+	Database        string     `json:"database"`
+	Freshness       string     `json:"freshness,omitempty"`
+	QuoteAgeSeconds *int64     `json:"quote_age_seconds,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+
+	// Only set with UPSTREAM_RATE_PER_MINUTE.
+	UpstreamBudget *int64 `json:"upstream_budget,omitempty"`
+}
+
+// healthHandler answers 200 while the database is reachable and, with
+// HEALTH_MAX_AGE set, the newest stored quote is recent enough, and 503
+// otherwise, reporting the last fetch or store error either way. Without
+// persistence there is neither to check.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	response := HealthResponse{Status: "ok", Database: "ok"}
+	status := http.StatusOK
+
+	if !persist {
+		response.Database = "disabled"
+	} else if err := pingDatabase(r.Context()); err != nil {
+		response.Status = "unavailable"
+		response.Database = err.Error()
+		status = http.StatusServiceUnavailable
+	} else if settings().healthMaxAge > 0 && !checkFreshness(&response) {
+		response.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	lastFailure.Lock()
+	if lastFailure.message != "" {
+		at := lastFailure.at
+		response.LastError = lastFailure.message
+		response.LastErrorAt = &at
+	}
+	lastFailure.Unlock()
+
+	if upstreamQuota != nil {
+		budget := upstreamQuota.remaining()
+		response.UpstreamBudget = &budget
+	}
+	writeJSONStatus(w, status, response)
+}
+
+func pingDatabase(ctx context.Context) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeoutProbe)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// checkFreshness fills in how old the newest stored quote is and reports
+// whether it is within HEALTH_MAX_AGE. An empty store passes, so a fresh
+// deployment is healthy before its first quote.
+func checkFreshness(response *HealthResponse) bool {
+	db, err := openDB()
+	if err != nil {
+		response.Freshness = err.Error()
+		return false
+	}
+	defer db.Close()
+
+	quote, err := latestStoredQuote(db)
+	if err != nil {
+		response.Freshness = err.Error()
+		return false
+	}
+	if quote == nil {
+		response.Freshness = "no quotes stored"
+		return true
+	}
+
+	maxAge := settings().healthMaxAge
+	age := max(clock().Unix()-quote.Timestamp, 0)
+	response.QuoteAgeSeconds = &age
+	if time.Duration(age)*time.Second > maxAge {
+		response.Freshness = fmt.Sprintf("newest quote is %ds old, over the %v limit", age, maxAge)
+		return false
+	}
+	response.Freshness = "ok"
+	return true
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// historyQuery selects stored quotes whose timestamp falls within
+// [from, to), ordered oldest first and paged by limit/offset. A zero to
+// leaves the range open-ended.
+type historyQuery struct {
+	from   time.Time
+	to     time.Time
+	limit  int
+	offset int
+}
+
+func parseHistoryQuery(r *http.Request) (historyQuery, error) {
+	query := r.URL.Query()
+	q := historyQuery{
+		limit: defaultHistoryLimit,
+	}
+
+	var err error
+	if raw := query.Get("from"); raw != "" {
+		if q.from, err = time.Parse(time.RFC3339, raw); err != nil {
+			return q, fmt.Errorf("invalid from: expected an RFC3339 time")
+		}
+	}
+	if raw := query.Get("to"); raw != "" {
+		if q.to, err = time.Parse(time.RFC3339, raw); err != nil {
+			return q, fmt.Errorf("invalid to: expected an RFC3339 time")
+		}
+	}
+	if !q.to.IsZero() && !q.from.Before(q.to) {
+		return q, fmt.Errorf("invalid range: from must be before to")
+	}
+	if raw := query.Get("limit"); raw != "" {
+		q.limit, err = strconv.Atoi(raw)
+		if err != nil || q.limit < 1 || q.limit > maxHistoryLimit {
+			return q, fmt.Errorf("invalid limit: expected 1 to %d", maxHistoryLimit)
+		}
+	}
+	if raw := query.Get("offset"); raw != "" {
+		q.offset, err = strconv.Atoi(raw)
+		if err != nil || q.offset < 0 {
+			return q, fmt.Errorf("invalid offset: expected a non-negative integer")
+		}
+	}
+	return q, nil
+}
+
+func (q historyQuery) toUnix() int64 {
+	if q.to.IsZero() {
+		return math.MaxInt64
+	}
+	return q.to.Unix()
+}
+
+// queryHistory returns one page of quotes along with the total number of
+// quotes in the range.
+func queryHistory(db *sql.DB, q historyQuery) ([]Quote, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutQuery)
+	defer cancel()
+
+	where, args := timestampRange(defaultPair, q.from.Unix(), q.toUnix())
+	var total int
+	err := db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM quotes WHERE "+where,
+		args...,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting quotes: %v", err)
+	}
+
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT bid, timestamp, create_date, backfilled FROM quotes
+        WHERE `+where+`
+        ORDER BY timestamp, id LIMIT ? OFFSET ?`,
+		append(args, q.limit, q.offset)...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying quotes: %v", err)
+	}
+	defer rows.Close()
+
+	quotes := []Quote{}
+	for rows.Next() {
+		var quote Quote
+		if err := rows.Scan(&quote.Bid, &quote.Timestamp, &quote.CreateDate, &quote.Backfilled); err != nil {
+			return nil, 0, fmt.Errorf("error reading quote: %v", err)
+		}
+		quotes = append(quotes, quote)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading quotes: %v", err)
+	}
+	return quotes, total, nil
+}
+
+// recentHistory answers q from recentQuotes, if the buffer covers its range.
+func recentHistory(q historyQuery) ([]Quote, int, bool) {
+	quotes, ok := recentQuotes.window(q.from.Unix(), q.toUnix())
+	if !ok {
+		return nil, 0, false
+	}
+	total := len(quotes)
+	start := min(q.offset, total)
+	end := min(start+q.limit, total)
+	return quotes[start:end], total, true
+}
+
+// historyCSV reports whether history was asked for as CSV, through
+// format=csv or an Accept header listing text/csv. JSON is the default.
+func historyCSV(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+	default:
+		return false, fmt.Errorf(`invalid format: expected "json" or "csv"`)
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accepted)
+		if err == nil && mediaType == "text/csv" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// writeHistoryCSV writes quotes as timestamp,bid,create_date rows under a
+// header line, the layout the client's import command reads.
+func writeHistoryCSV(w http.ResponseWriter, quotes []Quote) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"timestamp", "bid", "create_date"})
+	for _, quote := range quotes {
+		writer.Write([]string{
+			strconv.FormatInt(quote.Timestamp, 10),
+			strconv.FormatFloat(quote.Bid, 'f', -1, 64),
+			quote.CreateDate.UTC().Format(createDateLayout),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		slog.Error("Failed to write CSV history", "error", err)
+	}
+}
+
+func writeHistory(w http.ResponseWriter, asCSV bool, quotes []Quote, total int) {
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if asCSV {
+		writeHistoryCSV(w, quotes)
+		return
+	}
+	writeJSON(w, quotes)
+}
+
+func getHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid history query: %v", err), http.StatusBadRequest)
+		return
+	}
+	asCSV, err := historyCSV(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid history query: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if quotes, total, ok := recentHistory(q); ok {
+		writeHistory(w, asCSV, quotes, total)
+		return
+	}
+
+	db := openReadDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	loadRecentQuotes(db)
+
+	quotes, total, err := queryHistory(db, q)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to query history: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	writeHistory(w, asCSV, quotes, total)
+}
+
+// quoteAt returns the newest quote stored with a timestamp at or before at,
+// or nil when there is none.
+func quoteAt(db *sql.DB, at time.Time) (*Quote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutQuery)
+	defer cancel()
+
+	var quote Quote
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT bid, timestamp, create_date, backfilled FROM quotes
+        WHERE pair = ? AND timestamp <= ?
+        ORDER BY timestamp DESC, id DESC LIMIT 1`,
+		defaultPair,
+		at.Unix(),
+	).Scan(&quote.Bid, &quote.Timestamp, &quote.CreateDate, &quote.Backfilled)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("error querying quote: %v", err)
+	}
+	return &quote, nil
+}
+
+func getQuoteAtHandler(w http.ResponseWriter, r *http.Request) {
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("time"))
+	if err != nil {
+		http.Error(w, "Invalid time: expected an RFC3339 time", http.StatusBadRequest)
+		return
+	}
+
+	db := openReadDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	quote, err := quoteAt(db, at)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to query quote: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if quote == nil {
+		http.Error(
+			w,
+			fmt.Sprintf("No quote stored at or before %s", at.Format(time.RFC3339)),
+			http.StatusNotFound,
+		)
+		return
+	}
+	writeJSON(w, quote)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	timeoutImport = 2 * time.Second
+
+	// idempotencyTTL is how long a processed Idempotency-Key is remembered.
+	idempotencyTTL    = 24 * time.Hour
+	maxIdempotencyKey = 255
+
+	// importMaxBackoff caps the wait between attempts at an import while
+	// another write holds the lock.
+	importMaxBackoff = 50 * time.Millisecond
+)
+
+type ImportResponse struct {
+	Imported int `json:"imported"`
+}
+
+func ensureIdempotencyKeysExist(ctx context.Context, db execer) error {
+	createTableSQL := `
+    CREATE TABLE IF NOT EXISTS idempotency_keys (
+        key TEXT PRIMARY KEY,
+        imported INTEGER NOT NULL,
+        created_at BIGINT NOT NULL
+    );`
+
+	if _, err := db.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("error creating idempotency_keys table: %w", err)
+	}
+	return nil
+}
+
+// insertQuotes stores quotes in a single transaction, recording source in
+// their events, and returns how many were imported. When key is set and was
+// already processed within idempotencyTTL, nothing is inserted and the
+// original count is returned with replayed set; otherwise the key is
+// recorded alongside the rows. The transaction holds the write lock from
+// the key lookup on, so of two concurrent imports with one key the second
+// waits and then replays the first.
+func insertQuotes(db *sql.DB, quotes []Quote, key, source string) (imported int, replayed bool, err error) {
+	if !quoteWrites.begin() {
+		return 0, false, errWritesClosed
+	}
+	defer quoteWrites.end()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutImport)
+	defer cancel()
+
+	imported, replayed, err = execInsertQuotes(ctx, db, quotes, key, source)
+	for attempt := 0; isBusyError(err) && ctx.Err() == nil; attempt++ {
+		select {
+		case <-ctx.Done():
+		case <-time.After(min(insertBackoff<<attempt, importMaxBackoff)):
+			imported, replayed, err = execInsertQuotes(ctx, db, quotes, key, source)
+		}
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if !replayed {
+		slog.Info("Imported quotes", "count", len(quotes), "source", source)
+	}
+	return imported, replayed, nil
+}
+
+// execInsertQuotes is one attempt of insertQuotes.
+func execInsertQuotes(ctx context.Context, db *sql.DB, quotes []Quote, key, source string) (imported int, replayed bool, err error) {
+	now := clock()
+	err = inWriteTx(ctx, db, func(ctx context.Context, conn *sql.Conn) error {
+		if key != "" {
+			_, err := conn.ExecContext(
+				ctx,
+				"DELETE FROM idempotency_keys WHERE created_at < ?",
+				now.Add(-idempotencyTTL).Unix(),
+			)
+			if err != nil {
+				return fmt.Errorf("error expiring idempotency keys: %w", err)
+			}
+
+			err = conn.QueryRowContext(ctx, "SELECT imported FROM idempotency_keys WHERE key = ?", key).
+				Scan(&imported)
+			switch {
+			case err == nil:
+				replayed = true
+				return nil
+			case !errors.Is(err, sql.ErrNoRows):
+				return fmt.Errorf("error looking up idempotency key: %w", err)
+			}
+		}
+
+		stmt, err := conn.PrepareContext(
+			ctx,
+			`INSERT INTO quotes (pair, bid, timestamp, create_date, var_bid, pct_change, high, low, backfilled)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		)
+		if err != nil {
+			return fmt.Errorf("error preparing import statement: %w", err)
+		}
+		defer stmt.Close()
+
+		for _, quote := range quotes {
+			result, err := stmt.ExecContext(
+				ctx,
+				append(
+					append([]any{defaultPair, quote.Bid, quote.Timestamp, quote.CreateDate}, quote.movementValues()...),
+					quote.Backfilled,
+				)...,
+			)
+			if err != nil {
+				return fmt.Errorf("error importing quote %d: %w", quote.Timestamp, err)
+			}
+			id, err := result.LastInsertId()
+			if err == nil {
+				err = appendEvent(ctx, conn, id, &quote, source)
+			}
+			if err != nil {
+				return fmt.Errorf("error recording import of quote %d: %w", quote.Timestamp, err)
+			}
+		}
+
+		if key != "" {
+			_, err = conn.ExecContext(
+				ctx,
+				"INSERT INTO idempotency_keys (key, imported, created_at) VALUES (?, ?, ?)",
+				key,
+				len(quotes),
+				now.Unix(),
+			)
+			if err != nil {
+				return fmt.Errorf("error recording idempotency key: %w", err)
+			}
+		}
+		imported = len(quotes)
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return imported, replayed, nil
+}
+
+func importQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKey {
+		http.Error(
+			w,
+			fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKey),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	var quotes []Quote
+	if err := json.NewDecoder(r.Body).Decode(&quotes); err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Invalid import payload: %v", err),
+			bodyErrorStatus(err),
+		)
+		return
+	}
+	for _, quote := range quotes {
+		if quote.Bid <= 0 || quote.Timestamp <= 0 {
+			http.Error(
+				w,
+				fmt.Sprintf("Invalid quote in import payload: %+v", quote),
+				http.StatusBadRequest,
+			)
+			return
+		}
+	}
+
+	db := openQuotesDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	imported, replayed, err := insertQuotes(db, quotes, key, eventSourceImport)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to import quotes: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	} else {
+		// Imported rows can land anywhere in the timeline.
+		recentQuotes.invalidate()
+	}
+	writeJSON(w, ImportResponse{Imported: imported})
+}
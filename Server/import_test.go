@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const importBody = `[{"bid":5.1,"timestamp":1715900000},{"bid":5.2,"timestamp":1715900060}]`
+
+func importWithKey(key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/cotacao/import", strings.NewReader(importBody))
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	importQuotesHandler(rec, req)
+	return rec
+}
+
+func TestRepeatedIdempotencyKeyImportsOnce(t *testing.T) {
+	useTestDB(t)
+
+	first := importWithKey("batch-1")
+	second := importWithKey("batch-1")
+	for _, rec := range []*httptest.ResponseRecorder{first, second} {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != `{"imported":2}` {
+			t.Errorf("body %s, want the original count", got)
+		}
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("only the second import should be flagged as replayed")
+	}
+	if got := storedQuotes(t, defaultPair); got != 2 {
+		t.Errorf("%d rows stored, want 2", got)
+	}
+}
+
+func TestConcurrentIdempotencyKeyReplays(t *testing.T) {
+	useTestDB(t)
+
+	const imports = 20
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	recs := make([]*httptest.ResponseRecorder, imports)
+	for i := range imports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			recs[i] = importWithKey("batch-1")
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	fresh := 0
+	for _, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if rec.Header().Get("Idempotent-Replayed") == "" {
+			fresh++
+		}
+	}
+	if fresh != 1 {
+		t.Errorf("%d imports ran, want 1 with the rest replayed", fresh)
+	}
+	if got := storedQuotes(t, defaultPair); got != 2 {
+		t.Errorf("%d rows stored, want 2", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// callbackPattern only admits plain or dotted JavaScript identifiers, such
+// as render or widget.update, so a callback can't inject script.
+var callbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+const maxCallbackLength = 64
+
+// callbackParam reads the optional JSONP callback parameter. An invalid
+// name is answered with a 400 and ok set to false.
+func callbackParam(w http.ResponseWriter, r *http.Request) (callback string, ok bool) {
+	callback = r.URL.Query().Get("callback")
+	if callback == "" {
+		return "", true
+	}
+	if len(callback) > maxCallbackLength || !callbackPattern.MatchString(callback) {
+		http.Error(
+			w,
+			fmt.Sprintf("Invalid callback %q: expected a JavaScript identifier", callback),
+			http.StatusBadRequest,
+		)
+		return "", false
+	}
+	return callback, true
+}
+
+// writeQuote answers with response as JSON or, when the request names a
+// callback already checked by callbackParam, as JSONP.
+func writeQuote(w http.ResponseWriter, r *http.Request, response ClientResponse) {
+	callback := r.URL.Query().Get("callback")
+	if callback == "" {
+		writeJSON(w, response)
+		return
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to serialize response to JSON: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// The leading comment keeps the body from being read as anything
+	// but script, should a browser sniff it anyway.
+	fmt.Fprintf(w, "/**/%s(%s);", callback, responseJSON)
+}
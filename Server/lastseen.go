@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// lastStored remembers, per pair, the upstream timestamp of the newest
+// quote known to be in the database. The provider often repeats the same
+// quote for minutes; with timestamp-based storing such a repeat can't be
+// stored, so it is skipped without opening the database at all.
+var lastStored sync.Map
+
+// timestampDeduplicates reports whether storing is decided by the upstream
+// timestamp alone, which is what makes lastStored conclusive.
+func timestampDeduplicates() bool {
+	return storeMode == storeOnChange && minChange == 0
+}
+
+// alreadyStored reports whether quote repeats the last stored timestamp
+// for pair.
+func alreadyStored(pair string, quote *Quote) bool {
+	if !timestampDeduplicates() {
+		return false
+	}
+	last, ok := lastStored.Load(pair)
+	if !ok || last.(int64) != quote.Timestamp {
+		return false
+	}
+	slog.Debug("Quote unchanged; skipping the database", "pair", pair, "timestamp", quote.Timestamp)
+	return true
+}
+
+// rememberStored records that the database holds quote as pair's newest.
+func rememberStored(pair string, quote *Quote) {
+	if timestampDeduplicates() {
+		lastStored.Store(pair, quote.Timestamp)
+	}
+}
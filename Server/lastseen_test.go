@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRepeatedTimestampSkipsDatabase(t *testing.T) {
+	useTestDB(t)
+	quote := &Quote{Bid: 5.12, Timestamp: 1715952600, CreateDate: time.Unix(1715952600, 0).UTC()}
+	if err := persistQuote(context.Background(), defaultPair, quote); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the database out of reach, only a save that skips it succeeds.
+	dbPath = filepath.Join(t.TempDir(), "missing", "quotes.db")
+	repeat := *quote
+	if err := persistQuote(context.Background(), defaultPair, &repeat); err != nil {
+		t.Errorf("repeated timestamp went to the database: %v", err)
+	}
+	changed := *quote
+	changed.Timestamp++
+	if err := persistQuote(context.Background(), defaultPair, &changed); err == nil {
+		t.Error("new timestamp skipped the database")
+	}
+}
+
+func TestRepeatedTimestampIsCheckedPerPair(t *testing.T) {
+	useTestDB(t)
+	quote := &Quote{Bid: 5.12, Timestamp: 1715952600, CreateDate: time.Unix(1715952600, 0).UTC()}
+	if err := persistQuote(context.Background(), defaultPair, quote); err != nil {
+		t.Fatal(err)
+	}
+	if alreadyStored("EUR-BRL", quote) {
+		t.Error("USD-BRL's timestamp counted as stored for EUR-BRL")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// latestQuotes returns pair's n newest stored quotes, newest first, in one
+// query. Like latestStoredQuote it orders by timestamp, so an import of
+// older rows doesn't count as newer.
+func latestQuotes(ctx context.Context, db *sql.DB, pair string, n int) ([]Quote, error) {
+	quotes := []Quote{}
+	if n < 1 {
+		return quotes, nil
+	}
+
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT bid, timestamp, create_date, var_bid, pct_change, high, low, backfilled
+        FROM quotes WHERE pair = ? ORDER BY timestamp DESC, id DESC LIMIT ?`,
+		pair,
+		n,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying latest quotes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var quote Quote
+		err := rows.Scan(
+			&quote.Bid,
+			&quote.Timestamp,
+			&quote.CreateDate,
+			&quote.VarBid,
+			&quote.PctChange,
+			&quote.High,
+			&quote.Low,
+			&quote.Backfilled,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error reading latest quote: %v", err)
+		}
+		quotes = append(quotes, quote)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading latest quotes: %v", err)
+	}
+	return quotes, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// openLogOutput resolves a LOG_OUTPUT value to a writer. "stdout" and
+// "stderr" (the default) select the standard streams; anything else is
+// treated as a file path that is created or appended to.
+func openLogOutput(dest string) (io.Writer, func() error, error) {
+	switch dest {
+	case "", "stderr":
+		return os.Stderr, func() error { return nil }, nil
+	case "stdout":
+		return os.Stdout, func() error { return nil }, nil
+	}
+
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening log output %q: %v", dest, err)
+	}
+	return file, file.Close, nil
+}
+
+// logLevel is the minimum level logged, set from LOG_LEVEL.
+var logLevel slog.LevelVar
+
+func setupLogger() (func() error, error) {
+	out, closeOut, err := openLogOutput(getenv("LOG_OUTPUT"))
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: &logLevel})))
+	return closeOut, nil
+}
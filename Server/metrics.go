@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds what /metrics exposes: the newest stored bid per
+// pair, and the same counters /stats/internal reports.
+var metricsRegistry = prometheus.NewRegistry()
+
+var storedBid = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "dollar_bid",
+		Help: "Bid of the newest quote stored, by currency pair.",
+	},
+	[]string{"pair"},
+)
+
+// metricsHandler serves metricsRegistry in the Prometheus text format.
+var metricsHandler = newMetricsHandler()
+
+func newMetricsHandler() http.Handler {
+	metricsRegistry.MustRegister(
+		storedBid,
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "cotacao_cache_hits_total",
+				Help: "Quote requests answered with source cache, by a fetch already in flight, or from the polled quote.",
+			},
+			func() float64 { return float64(servedCounters.cacheHits.Load()) },
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "cotacao_upstream_fetches_total",
+				Help: "Requests sent to the quote providers.",
+			},
+			func() float64 { return float64(servedCounters.upstreamFetches.Load()) },
+		),
+	)
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// recordStoredBid sets the dollar_bid gauge after quote is stored.
+func recordStoredBid(pair string, quote *Quote) {
+	storedBid.WithLabelValues(pair).Set(quote.Bid)
+}
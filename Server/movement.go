@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// movementColumns are the nullable quotes columns holding the provider's
+// daily movement figures, in the order movementValues lists them.
+var movementColumns = []string{"var_bid", "pct_change", "high", "low"}
+
+// movementValues returns the movement fields as insert arguments, nil
+// where the provider didn't send one.
+func (q *Quote) movementValues() []any {
+	return []any{q.VarBid, q.PctChange, q.High, q.Low}
+}
+
+// upstreamFloat reads an optional numeric string field of an upstream quote.
+// A missing or malformed value is nil rather than an error, since these
+// figures are extras the quote is still usable without.
+func upstreamFloat(rate map[string]interface{}, key string) *float64 {
+	raw, ok := rate[key].(string)
+	if !ok {
+		return nil
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+func prepareMovementColumns() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return retrySchema(db, addMovementColumns)
+}
+
+// addMovementColumns adds whichever movement columns a quotes table created
+// before they existed lacks. Old rows keep NULL there.
+func addMovementColumns(db *sql.DB) error {
+	return inWriteTx(context.Background(), db, func(ctx context.Context, conn *sql.Conn) error {
+		for _, column := range movementColumns {
+			var exists int
+			err := conn.QueryRowContext(
+				ctx,
+				"SELECT COUNT(*) FROM pragma_table_xinfo('quotes') WHERE name = ?",
+				column,
+			).Scan(&exists)
+			if err != nil {
+				return fmt.Errorf("error inspecting quotes table: %w", err)
+			}
+			if exists > 0 {
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, "ALTER TABLE quotes ADD COLUMN "+column+" DECIMAL(10, 4)"); err != nil {
+				return fmt.Errorf("error adding %s column: %w", column, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const maxMultiPairs = 10
+
+// multiPartial is MULTI_PAIR_PARTIAL: whether /cotacao/multi answers with
+// the pairs it got when others failed, rather than failing as a whole.
+var multiPartial = true
+
+// MultiResponse holds the quotes /cotacao/multi got, by pair. When some
+// pairs failed it is flagged partial and failed says why, by pair.
+type MultiResponse struct {
+	Quotes  map[string]ClientResponse `json:"quotes"`
+	Partial bool                      `json:"partial,omitempty"`
+	Failed  map[string]string         `json:"failed,omitempty"`
+}
+
+// parsePairList reads a comma-separated list of distinct pairs.
+func parsePairList(raw string) ([]string, error) {
+	var pairs []string
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.ToUpper(strings.TrimSpace(pair))
+		if !pairPattern.MatchString(pair) {
+			return nil, fmt.Errorf("invalid currency pair %q", pair)
+		}
+		if !slices.Contains(pairs, pair) {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) > maxMultiPairs {
+		return nil, fmt.Errorf("at most %d pairs can be asked for at once", maxMultiPairs)
+	}
+	return pairs, nil
+}
+
+// multiQuoteHandler fetches the current quotes of several pairs at once,
+// batched like compare. A pair the provider fails or leaves out doesn't
+// fail the others unless MULTI_PAIR_PARTIAL is off; only when every pair
+// failed is the request answered with an error. As by /cotacao, only
+// USD-BRL is stored, since the ring, history and events only hold the
+// default pair; other pairs are passed through unstored.
+func multiQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	pairs, err := parsePairList(r.URL.Query().Get("pairs"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid pairs: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	quotes, failed, err := fetchSomeQuotes(r.Context(), pairs)
+	if err == nil && (len(quotes) == 0 || (len(failed) > 0 && !multiPartial)) {
+		for _, pair := range pairs {
+			if err = failed[pair]; err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to get quotations: %v", err),
+			upstreamStatus(err),
+		)
+		return
+	}
+
+	if quote, ok := quotes[defaultPair]; ok {
+		if err := persistQuote(r.Context(), defaultPair, quote); err != nil {
+			recordFailure(err)
+			slog.Warn("Serving a quotation that wasn't saved", "error", err)
+			w.Header().Set("X-Quote-Saved", "false")
+		}
+	}
+
+	response := MultiResponse{Quotes: make(map[string]ClientResponse, len(quotes))}
+	for pair, quote := range quotes {
+		response.Quotes[pair] = ClientResponse{Bid: quote.Bid, Source: quoteSourceLive, pair: pair}
+	}
+	if len(failed) > 0 {
+		response.Partial = true
+		response.Failed = make(map[string]string, len(failed))
+		for pair, err := range failed {
+			response.Failed[pair] = err.Error()
+		}
+		slog.Warn("Answering with part of the pairs", "failed", len(failed), "of", len(pairs))
+	}
+	// A partial answer is worth asking again for rather than keeping.
+	setCacheControl(w, response.Partial)
+	writeJSON(w, response)
+}
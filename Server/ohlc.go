@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const maxCandles = 10000
+
+// Candle is the open/high/low/close of the bids stored within one interval
+// starting at T (Unix seconds).
+type Candle struct {
+	T int64   `json:"t"`
+	O float64 `json:"o"`
+	H float64 `json:"h"`
+	L float64 `json:"l"`
+	C float64 `json:"c"`
+}
+
+// candleBuilder buckets bids, fed in timestamp order, into candles of one
+// interval covering [from, to). With fill set, buckets without quotes are
+// returned as zero candles instead of being omitted.
+type candleBuilder struct {
+	candles []Candle
+	start   int64
+	end     int64
+	step    int64
+	fill    bool
+}
+
+func newCandleBuilder(from, to time.Time, interval time.Duration, fill bool) *candleBuilder {
+	step := int64(interval / time.Second)
+	return &candleBuilder{
+		candles: []Candle{},
+		start:   from.Unix() - from.Unix()%step,
+		end:     to.Unix(),
+		step:    step,
+		fill:    fill,
+	}
+}
+
+func (b *candleBuilder) add(bid float64, timestamp int64) {
+	bucket := timestamp - timestamp%b.step
+	last := len(b.candles) - 1
+	if last >= 0 && b.candles[last].T == bucket {
+		b.candles[last].H = max(b.candles[last].H, bid)
+		b.candles[last].L = min(b.candles[last].L, bid)
+		b.candles[last].C = bid
+		return
+	}
+	if b.fill {
+		b.fillTo(bucket)
+	}
+	b.candles = append(b.candles, Candle{T: bucket, O: bid, H: bid, L: bid, C: bid})
+}
+
+// fillTo appends zero candles for the empty buckets before end.
+func (b *candleBuilder) fillTo(end int64) {
+	next := b.start
+	if len(b.candles) > 0 {
+		next = b.candles[len(b.candles)-1].T + b.step
+	}
+	for ; next < end; next += b.step {
+		b.candles = append(b.candles, Candle{T: next})
+	}
+}
+
+func (b *candleBuilder) finish() []Candle {
+	if b.fill {
+		b.fillTo(b.end)
+	}
+	return b.candles
+}
+
+// queryCandles buckets the quotes whose upstream timestamp falls within
+// [from, to) into candles of the given interval. Backfilled rows only
+// repeat the bid before them, so they are left out rather than opening or
+// making up candles.
+func queryCandles(db *sql.DB, from, to time.Time, interval time.Duration, fill bool) ([]Candle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutQuery)
+	defer cancel()
+
+	where, args := timestampRange(defaultPair, from.Unix(), to.Unix())
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT bid, timestamp FROM quotes WHERE "+where+" AND NOT backfilled ORDER BY timestamp, id",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying quotes: %v", err)
+	}
+	defer rows.Close()
+
+	builder := newCandleBuilder(from, to, interval, fill)
+	for rows.Next() {
+		var bid float64
+		var timestamp int64
+		if err := rows.Scan(&bid, &timestamp); err != nil {
+			return nil, fmt.Errorf("error reading quote: %v", err)
+		}
+		builder.add(bid, timestamp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading quotes: %v", err)
+	}
+	return builder.finish(), nil
+}
+
+// recentCandles buckets the quotes of [from, to) from recentQuotes, if the
+// buffer covers that window.
+func recentCandles(from, to time.Time, interval time.Duration, fill bool) ([]Candle, bool) {
+	quotes, ok := recentQuotes.window(from.Unix(), to.Unix())
+	if !ok {
+		return nil, false
+	}
+	builder := newCandleBuilder(from, to, interval, fill)
+	for _, quote := range quotes {
+		if !quote.Backfilled {
+			builder.add(quote.Bid, quote.Timestamp)
+		}
+	}
+	return builder.finish(), true
+}
+
+func getOHLCHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	interval, err := durationParam(query.Get("interval"), time.Hour)
+	if err != nil || interval < time.Second {
+		http.Error(w, "Invalid interval: expected a duration of at least 1s", http.StatusBadRequest)
+		return
+	}
+	window, err := durationParam(query.Get("window"), 24*time.Hour)
+	if err != nil || window < interval {
+		http.Error(w, "Invalid window: expected a duration no shorter than interval", http.StatusBadRequest)
+		return
+	}
+	if window/interval > maxCandles {
+		http.Error(
+			w,
+			fmt.Sprintf("Too many candles requested: at most %d per query", maxCandles),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	var fill bool
+	switch query.Get("fill") {
+	case "", "none":
+	case "zero":
+		fill = true
+	default:
+		http.Error(w, `Invalid fill: expected "none" or "zero"`, http.StatusBadRequest)
+		return
+	}
+
+	to := clock()
+	from := to.Add(-window)
+	if candles, ok := recentCandles(from, to, interval, fill); ok {
+		writeJSON(w, candles)
+		return
+	}
+
+	db := openReadDB(w)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	loadRecentQuotes(db)
+
+	candles, err := queryCandles(db, from, to, interval, fill)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to aggregate quotes: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	writeJSON(w, candles)
+}
+
+// durationParam parses an optional duration query parameter.
+func durationParam(raw string, def time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return def, nil
+	}
+	return time.ParseDuration(raw)
+}
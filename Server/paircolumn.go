@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+func preparePairColumn() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return retrySchema(db, addPairColumn)
+}
+
+// addPairColumn adds the pair column to a quotes table created before it
+// existed, filling in defaultPair for the rows already stored, since that
+// was the only pair persisted, and indexes (pair, timestamp), which every
+// query filters and orders by. The index isn't unique: STORE_MODE=always
+// stores the fetch time, which two fetches in one second share, and
+// imports may repeat a timestamp.
+func addPairColumn(db *sql.DB) error {
+	return inWriteTx(context.Background(), db, func(ctx context.Context, conn *sql.Conn) error {
+		var exists int
+		err := conn.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM pragma_table_xinfo('quotes') WHERE name = 'pair'",
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error inspecting quotes table: %w", err)
+		}
+		if exists == 0 {
+			_, err = conn.ExecContext(ctx, fmt.Sprintf(
+				"ALTER TABLE quotes ADD COLUMN pair TEXT NOT NULL DEFAULT '%s'",
+				defaultPair,
+			))
+			if err != nil {
+				return fmt.Errorf("error adding pair column: %w", err)
+			}
+		}
+		_, err = conn.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS quotes_pair_timestamp ON quotes (pair, timestamp)")
+		if err != nil {
+			return fmt.Errorf("error creating pair index: %w", err)
+		}
+		return nil
+	})
+}
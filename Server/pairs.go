@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultPair        = "USD-BRL"
+	defaultProviderURL = "https://economia.awesomeapi.com.br/json/last/"
+)
+
+var pairPattern = regexp.MustCompile(`^[A-Z0-9]{2,10}-[A-Z0-9]{2,10}$`)
+
+// upstreamAPIKey, when set, is sent as x-api-key to awesomeapi. It is never
+// sent to the providers configured in PAIR_PROVIDERS.
+var upstreamAPIKey string
+
+// pairURLs maps a currency pair to the endpoint its quote is fetched from.
+// Pairs without an entry use the standard awesomeapi URL.
+var pairURLs = map[string]string{}
+
+// parsePairURLs reads a PAIR_PROVIDERS value in the form
+// "BTC-BRL=https://host/path,ETH-BRL=https://other/path".
+func parsePairURLs(spec string) (map[string]string, error) {
+	urls := map[string]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pair, rawURL, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid provider mapping %q: expected PAIR=URL", entry)
+		}
+		pair = strings.ToUpper(strings.TrimSpace(pair))
+		if !pairPattern.MatchString(pair) {
+			return nil, fmt.Errorf("invalid pair %q in provider mapping", pair)
+		}
+		rawURL = strings.TrimSpace(rawURL)
+		if u, err := url.Parse(rawURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid provider URL %q for %s", rawURL, pair)
+		}
+		urls[pair] = rawURL
+	}
+	return urls, nil
+}
+
+const maxPrecision = 12
+
+var (
+	// bidPrecision is how many decimals bids are returned with, unless
+	// pairPrecisions gives a pair its own.
+	bidPrecision   = 4
+	pairPrecisions = map[string]int{}
+)
+
+// parsePairPrecisions reads a PAIR_PRECISION value in the form
+// "BTC-BRL=8,ETH-BRL=8".
+func parsePairPrecisions(spec string) (map[string]int, error) {
+	precisions := map[string]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pair, raw, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid precision %q: expected PAIR=DECIMALS", entry)
+		}
+		pair = strings.ToUpper(strings.TrimSpace(pair))
+		if !pairPattern.MatchString(pair) {
+			return nil, fmt.Errorf("invalid pair %q in precision mapping", pair)
+		}
+		decimals, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || decimals < 0 || decimals > maxPrecision {
+			return nil, fmt.Errorf("invalid precision %q for %s: expected 0 to %d", raw, pair, maxPrecision)
+		}
+		precisions[pair] = decimals
+	}
+	return precisions, nil
+}
+
+func precisionFor(pair string) int {
+	if decimals, ok := pairPrecisions[pair]; ok {
+		return decimals
+	}
+	return bidPrecision
+}
+
+// formatBid writes bid with pair's precision as a JSON number.
+func formatBid(bid float64, pair string) json.Number {
+	return json.Number(strconv.FormatFloat(bid, 'f', precisionFor(pair), 64))
+}
+
+// precisionWarned remembers the pairs already warned about by checkPrecision.
+var precisionWarned sync.Map
+
+// checkPrecision warns, once per pair, when the provider quotes a bid with
+// more decimals than are returned for the pair, or than a float64 holds, so
+// the rounding isn't silent.
+func checkPrecision(pair, bidStr string, bid float64) {
+	quoted := bidStr
+	if strings.Contains(quoted, ".") {
+		quoted = strings.TrimRight(strings.TrimRight(quoted, "0"), ".")
+	}
+	_, decimals, _ := strings.Cut(quoted, ".")
+
+	var reason string
+	switch {
+	case strconv.FormatFloat(bid, 'f', -1, 64) != quoted:
+		reason = "more digits than a float64 holds"
+	case len(decimals) > precisionFor(pair):
+		reason = "more decimals than its configured precision"
+	default:
+		return
+	}
+	if _, warned := precisionWarned.LoadOrStore(pair, true); !warned {
+		slog.Warn(
+			"Upstream bid is rounded",
+			"pair", pair,
+			"bid", bidStr,
+			"precision", precisionFor(pair),
+			"reason", reason,
+		)
+	}
+}
+
+func providerURL(pair string) string {
+	if u, ok := pairURLs[pair]; ok {
+		return u
+	}
+	return defaultProviderURL + pair
+}
+
+// pairKey is the key awesomeapi uses for a pair in its response body.
+func pairKey(pair string) string {
+	return strings.ReplaceAll(pair, "-", "")
+}
+
+// pairParam reads the optional pair query parameter, defaulting to
+// defaultPair. An invalid pair is answered with a 400 and ok set to false.
+func pairParam(w http.ResponseWriter, r *http.Request) (pair string, ok bool) {
+	pair = strings.ToUpper(r.URL.Query().Get("pair"))
+	if pair == "" {
+		return defaultPair, true
+	}
+	if !pairPattern.MatchString(pair) {
+		http.Error(
+			w,
+			fmt.Sprintf("Invalid currency pair %q", pair),
+			http.StatusBadRequest,
+		)
+		return "", false
+	}
+	return pair, true
+}
@@ -0,0 +1,20 @@
+package main
+
+import "net/http"
+
+// persist is turned off with PERSIST=false, where the server is a pure
+// pass-through proxy: /cotacao fetches and returns quotes without ever
+// opening the database.
+var persist = true
+
+// requirePersistence answers 501 in place of next while persistence is
+// off, for endpoints that only exist to read or write stored quotes.
+func requirePersistence(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !persist {
+			http.Error(w, "Not available with PERSIST=false: this server stores no quotes", http.StatusNotImplemented)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// quotePoller is the background poller, or nil when neither POLL_INTERVAL
+// nor POLL_CRON is set.
+var quotePoller *poller
+
+// pollSchedule, set from POLL_CRON, polls at the times it gives instead of
+// on a fixed interval, e.g. only during market hours.
+var pollSchedule cron.Schedule
+
+type pollResult struct {
+	quote *Quote
+	err   error
+}
+
+// poller fetches and stores the default pair on a fixed interval, or at
+// the times of a cron schedule. Refresh requests are handled by the same
+// goroutine, so a forced poll never races a scheduled one.
+type poller struct {
+	interval  time.Duration
+	schedule  cron.Schedule
+	intervals chan time.Duration
+	requests  chan chan pollResult
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+var errPollerStopped = errors.New("poller is shutting down")
+
+// newPoller polls every interval, or on schedule when that is set.
+func newPoller(interval time.Duration, schedule cron.Schedule) *poller {
+	return &poller{
+		interval:  interval,
+		schedule:  schedule,
+		intervals: make(chan time.Duration),
+		requests:  make(chan chan pollResult),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+func (p *poller) run() {
+	defer close(p.stopped)
+
+	var ticker *time.Ticker
+	var timer *time.Timer
+	var ticks <-chan time.Time
+	if p.schedule != nil {
+		timer = time.NewTimer(p.untilScheduled())
+		defer timer.Stop()
+		ticks = timer.C
+	} else {
+		ticker = time.NewTicker(p.interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticks:
+			pollQuote(context.Background())
+			if timer != nil {
+				timer.Reset(p.untilScheduled())
+			}
+		case interval := <-p.intervals:
+			if ticker != nil && interval != p.interval {
+				p.interval = interval
+				ticker.Reset(interval)
+			}
+		case reply := <-p.requests:
+			quote, err := pollQuote(context.Background())
+			reply <- pollResult{quote: quote, err: err}
+		}
+	}
+}
+
+// untilScheduled is how long until the schedule's next poll.
+func (p *poller) untilScheduled() time.Duration {
+	now := time.Now()
+	return p.schedule.Next(now).Sub(now)
+}
+
+// stop tells the poller to exit and waits up to drainTimeout for a poll in
+// flight to finish storing its quote. It reports whether the poller exited
+// in time.
+func (p *poller) stop(drainTimeout time.Duration) bool {
+	close(p.done)
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-p.stopped:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// setInterval moves the poller to a new interval, counted from now. It
+// waits for a poll in flight, and does nothing once the poller stops.
+func (p *poller) setInterval(interval time.Duration) {
+	select {
+	case p.intervals <- interval:
+	case <-p.done:
+	}
+}
+
+// refresh makes the poller fetch immediately and waits for the outcome.
+func (p *poller) refresh(ctx context.Context) (*Quote, error) {
+	reply := make(chan pollResult, 1)
+	select {
+	case p.requests <- reply:
+	case <-p.done:
+		return nil, errPollerStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case result := <-reply:
+		return result.quote, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pollQuote fetches the default pair and stores it per storeMode.
+func pollQuote(ctx context.Context) (*Quote, error) {
+	quote, err := fetchQuote(ctx, defaultPair)
+	if err != nil {
+		recordFailure(err)
+		slog.Error("Poll failed to fetch quotation", "error", err)
+		return nil, err
+	}
+
+	if err := persistQuote(ctx, defaultPair, quote); err != nil {
+		recordFailure(err)
+		slog.Error("Poll failed to save quotation", "error", err)
+		return nil, err
+	}
+	recordSuccess()
+	quoteUpdates.publish(quote)
+	return quote, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+var (
+	// pprofEnabled starts the runtime profiling endpoints on pprofAddr.
+	pprofEnabled bool
+	pprofAddr    = "localhost:6060"
+)
+
+// servePprof serves net/http/pprof under /debug/pprof/, and the admin-only
+// /debug/cache, on its own listener, bound to localhost by default, so
+// profiles never share the public port.
+func servePprof() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/cache", requireAdmin(debugCacheHandler))
+
+	slog.Info("Serving pprof", "addr", pprofAddr)
+	if err := http.ListenAndServe(pprofAddr, mux); err != nil {
+		slog.Error("pprof server stopped", "error", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// upstreamQuota spaces upstream fetches out to UPSTREAM_RATE_PER_MINUTE, so
+// the server stays within the provider's quota however many requests and
+// polls ask for quotes. Nil, the default, leaves them unlimited.
+var upstreamQuota *tokenBucket
+
+// tokenBucket hands out up to burst tokens at once, refilled at rate per
+// second. A caller may take a token before it is there and wait for it, so
+// waiting callers are served in turn.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(perMinute float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   perMinute / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock(),
+	}
+}
+
+// setUpstreamQuota limits upstream fetches to perMinute, with up to burst
+// at once, and exposes the remaining budget on /metrics.
+func setUpstreamQuota(perMinute float64, burst int) {
+	upstreamQuota = newTokenBucket(perMinute, burst)
+	metricsRegistry.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "cotacao_upstream_budget",
+			Help: "Upstream fetches that can start right now under UPSTREAM_RATE_PER_MINUTE.",
+		},
+		func() float64 { return float64(upstreamQuota.remaining()) },
+	))
+}
+
+// setLimits changes the bucket to perMinute and burst. The tokens earned so
+// far are kept, up to the new burst.
+func (b *tokenBucket) setLimits(perMinute float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(clock())
+	b.rate = perMinute / 60
+	b.burst = float64(burst)
+	b.tokens = min(b.tokens, b.burst)
+}
+
+// refill adds the tokens earned since the last call. It must be called
+// with mu held.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, b.burst)
+		b.last = now
+	}
+}
+
+// remaining is how many tokens can be taken right now without waiting.
+func (b *tokenBucket) remaining() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(clock())
+	return int64(math.Max(math.Floor(b.tokens), 0))
+}
+
+// take waits for a token until ctx is done. When ctx's deadline would pass
+// before one is due, it fails at once instead of waiting in vain.
+func (b *tokenBucket) take(ctx context.Context) error {
+	b.mu.Lock()
+	now := clock()
+	b.refill(now)
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+		b.mu.Unlock()
+		return fmt.Errorf("next upstream fetch allowed in %v", wait.Round(time.Millisecond))
+	}
+	b.tokens--
+	b.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// The token was never used, so the next caller gets it.
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// takeUpstreamQuota waits for upstreamQuota to allow a fetch. Running out
+// of budget counts as the provider being unavailable, so SERVE_STALE
+// applies and the provider is never asked for more than it allows.
+func takeUpstreamQuota(ctx context.Context) error {
+	if upstreamQuota == nil {
+		return nil
+	}
+	if err := upstreamQuota.take(ctx); err != nil {
+		return unavailableUpstream(fmt.Errorf("upstream quota exhausted (UPSTREAM_RATE_PER_MINUTE): %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultRecentQuotes = 1000
+
+// recentQuotes holds the newest stored quotes so history and OHLC requests
+// over a recent window are answered without querying SQLite.
+var recentQuotes = newQuoteRing(defaultRecentQuotes)
+
+// quoteRing is a fixed-size circular buffer of the newest quotes, oldest
+// first. It mirrors the tail of the quotes table only as long as every row
+// is added through it in timestamp order; anything else unloads it until
+// the next ensureLoaded reads the tail again.
+type quoteRing struct {
+	mu     sync.Mutex
+	quotes []ringQuote
+	start  int
+	count  int
+	loaded bool
+	// truncated is set when the table may hold quotes older than the
+	// oldest one buffered.
+	truncated bool
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// ringQuote is a buffered quote along with its row id, which orders quotes
+// sharing a timestamp and tells whether a row is already buffered.
+type ringQuote struct {
+	id int64
+	Quote
+}
+
+// newQuoteRing makes a buffer of size quotes. A size of 0 disables it.
+func newQuoteRing(size int) *quoteRing {
+	return &quoteRing{quotes: make([]ringQuote, size)}
+}
+
+func (q *quoteRing) at(i int) ringQuote {
+	return q.quotes[(q.start+i)%len(q.quotes)]
+}
+
+// ensureLoaded reads the newest quotes from db unless the buffer already
+// mirrors them.
+func (q *quoteRing) ensureLoaded(db *sql.DB) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.loaded || len(q.quotes) == 0 {
+		return
+	}
+	if err := q.load(db); err != nil {
+		slog.Warn("Could not load recent quotes", "error", err)
+	}
+}
+
+func (q *quoteRing) isLoaded() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.loaded
+}
+
+func (q *quoteRing) load(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutQuery)
+	defer cancel()
+
+	rows, err := db.QueryContext(
+		ctx,
+		"SELECT id, bid, timestamp, create_date, backfilled FROM quotes WHERE pair = ? ORDER BY timestamp DESC, id DESC LIMIT ?",
+		defaultPair,
+		len(q.quotes),
+	)
+	if err != nil {
+		return fmt.Errorf("error querying quotes: %v", err)
+	}
+	defer rows.Close()
+
+	size := len(q.quotes)
+	count := 0
+	for rows.Next() {
+		quote := &q.quotes[size-1-count]
+		if err := rows.Scan(&quote.id, &quote.Bid, &quote.Timestamp, &quote.CreateDate, &quote.Backfilled); err != nil {
+			return fmt.Errorf("error reading quote: %v", err)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading quotes: %v", err)
+	}
+
+	q.start = (size - count) % size
+	q.count = count
+	q.truncated = count == size
+	q.loaded = true
+	return nil
+}
+
+// add records a quote just inserted into the database as row id.
+func (q *quoteRing) add(id int64, quote Quote) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.loaded {
+		return
+	}
+	// A row committed before the buffer was loaded is already in it.
+	for i := q.count - 1; i >= 0 && q.at(i).Timestamp >= quote.Timestamp; i-- {
+		if q.at(i).id == id {
+			return
+		}
+	}
+	if q.count > 0 {
+		newest := q.at(q.count - 1)
+		if quote.Timestamp < newest.Timestamp || (quote.Timestamp == newest.Timestamp && id < newest.id) {
+			q.loaded = false
+			return
+		}
+	}
+
+	entry := ringQuote{id: id, Quote: quote}
+
+	size := len(q.quotes)
+	if q.count < size {
+		q.quotes[(q.start+q.count)%size] = entry
+		q.count++
+		return
+	}
+	q.quotes[q.start] = entry
+	q.start = (q.start + 1) % size
+	q.truncated = true
+}
+
+// invalidate unloads the buffer after rows were written around it.
+func (q *quoteRing) invalidate() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.loaded = false
+}
+
+// window returns the buffered quotes with from <= timestamp < to, oldest
+// first, and whether the buffer holds every stored quote of that range.
+func (q *quoteRing) window(from, to int64) ([]Quote, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// Quotes sharing the oldest buffered timestamp may have been evicted,
+	// so a truncated buffer only covers ranges starting after it.
+	if !q.loaded || (q.truncated && (q.count == 0 || from <= q.at(0).Timestamp)) {
+		q.misses.Add(1)
+		return nil, false
+	}
+
+	quotes := []Quote{}
+	for i := 0; i < q.count; i++ {
+		quote := q.at(i).Quote
+		if quote.Timestamp >= from && quote.Timestamp < to {
+			quotes = append(quotes, quote)
+		}
+	}
+	q.hits.Add(1)
+	return quotes, true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// seedQuotes stores count quotes a minute apart, the newest at newest.
+func seedQuotes(b *testing.B, count int, newest time.Time) {
+	b.Helper()
+	db, err := openDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	quotes := make([]Quote, count)
+	for i := range quotes {
+		at := newest.Add(-time.Duration(count-1-i) * time.Minute)
+		quotes[i] = Quote{Bid: 5 + float64(i)/1000, Timestamp: at.Unix(), CreateDate: at.UTC()}
+	}
+	if _, _, err := insertQuotes(db, quotes, "", eventSourceImport); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkRecentHistory asks for the last hour of history with and without
+// the in-memory ring, reporting how many requests per op had to query the
+// database.
+func BenchmarkRecentHistory(b *testing.B) {
+	newest := time.Unix(1715952600, 0)
+	url := fmt.Sprintf("/cotacao/history?from=%s", newest.Add(-time.Hour).UTC().Format(time.RFC3339))
+
+	for name, size := range map[string]int{"ring": defaultRecentQuotes, "sql": 0} {
+		b.Run(name, func(b *testing.B) {
+			useTestDB(b)
+			seedQuotes(b, 500, newest)
+			previous := recentQuotes
+			recentQuotes = newQuoteRing(size)
+			b.Cleanup(func() { recentQuotes = previous })
+
+			misses := recentQuotes.misses.Load()
+			b.ResetTimer()
+			for range b.N {
+				rec := httptest.NewRecorder()
+				getHistoryHandler(rec, httptest.NewRequest("GET", url, nil))
+				if rec.Code != http.StatusOK {
+					b.Fatalf("status %d: %s", rec.Code, rec.Body)
+				}
+			}
+			b.ReportMetric(float64(recentQuotes.misses.Load()-misses)/float64(b.N), "db-queries/op")
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// upstreamMaxRedirects is how many redirects an upstream fetch
+	// follows; zero refuses any.
+	upstreamMaxRedirects = 3
+
+	// upstreamSameHost refuses redirects to another host, so a fetch, and
+	// the x-api-key it carries, can't be sent somewhere unexpected.
+	upstreamSameHost = true
+)
+
+var errUpstreamRedirect = errors.New("upstream redirect refused")
+
+// checkUpstreamRedirect is upstreamClient's CheckRedirect.
+func checkUpstreamRedirect(req *http.Request, via []*http.Request) error {
+	original := via[0].URL
+	switch {
+	case len(via) > upstreamMaxRedirects:
+		return fmt.Errorf("%w: more than %d redirects", errUpstreamRedirect, upstreamMaxRedirects)
+	case original.Scheme == "https" && req.URL.Scheme != "https":
+		return fmt.Errorf("%w: from https to %s", errUpstreamRedirect, req.URL.Scheme)
+	case req.URL.Host != original.Host && upstreamSameHost:
+		return fmt.Errorf("%w: from %s to another host, %s", errUpstreamRedirect, original.Host, req.URL.Host)
+	case req.URL.Host != original.Host:
+		// net/http only drops its own sensitive headers across hosts.
+		req.Header.Del("x-api-key")
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// configFile is the CONFIG_FILE the server reads its settings from, on
+	// top of the environment, and re-reads on SIGHUP.
+	configFile string
+
+	// configValues holds configFile's NAME=value lines, which take
+	// precedence over the environment.
+	configValues = map[string]string{}
+)
+
+// getenv looks a setting up in configFile, then in the environment.
+func getenv(name string) string {
+	if value, ok := configValues[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// readConfigFile parses lines of NAME=value. Blank lines and lines starting
+// with # are skipped, and a value may be wrapped in double quotes.
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %v", err)
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("error in config file %s line %d: expected NAME=value", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		values[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	return values, nil
+}
+
+// loadConfigFile reads CONFIG_FILE, when set, ahead of everything else
+// that reads settings.
+func loadConfigFile() error {
+	configFile = os.Getenv("CONFIG_FILE")
+	if configFile == "" {
+		return nil
+	}
+	values, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	configValues = values
+	return nil
+}
+
+// reloadableSettings are the settings a SIGHUP applies to the running
+// server. They are swapped as a whole, so a request never sees half of a
+// reload.
+type reloadableSettings struct {
+	logLevel slog.Level
+
+	// pollInterval enables the background poller when positive. A reload
+	// can change it, but not turn polling on or off.
+	pollInterval time.Duration
+
+	// cacheMaxAge is how long caches may reuse a /cotacao answer. It
+	// defaults to the poll interval, since a polled quote is replaced no
+	// sooner than that; without polling it is zero and caches must
+	// revalidate every time.
+	cacheMaxAge time.Duration
+
+	// serveStale lets /cotacao answer with the newest stored quote, flagged
+	// as stale, while the provider is down.
+	serveStale bool
+
+	// maxStale, when set, is the oldest a stale quote may be; past it a
+	// down provider is answered with 503 instead.
+	maxStale time.Duration
+
+	// healthMaxAge, when set, makes /health fail once the newest stored
+	// quote is older than this, which catches a poller that stalled while
+	// the database still answers.
+	healthMaxAge time.Duration
+
+	// upstreamRate is UPSTREAM_RATE_PER_MINUTE, zero when unlimited, and
+	// upstreamBurst UPSTREAM_BURST. A reload can change them, but not turn
+	// the quota on or off.
+	upstreamRate  float64
+	upstreamBurst int
+}
+
+// reloadableNames are the variables behind reloadableSettings.
+var reloadableNames = []string{
+	"LOG_LEVEL", "POLL_INTERVAL", "CACHE_MAX_AGE", "SERVE_STALE", "MAX_STALE", "HEALTH_MAX_AGE",
+	"UPSTREAM_RATE_PER_MINUTE", "UPSTREAM_BURST",
+}
+
+var liveSettings atomic.Pointer[reloadableSettings]
+
+// settings returns the reloadable settings in effect.
+func settings() *reloadableSettings {
+	return liveSettings.Load()
+}
+
+func loadReloadable() (*reloadableSettings, error) {
+	s := &reloadableSettings{}
+	if raw := getenv("LOG_LEVEL"); raw != "" {
+		if err := s.logLevel.UnmarshalText([]byte(raw)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q: expected debug, info, warn or error", raw)
+		}
+	}
+
+	var err error
+	if s.pollInterval, err = envDuration("POLL_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+	if s.pollInterval < 0 {
+		return nil, fmt.Errorf("invalid POLL_INTERVAL: must not be negative")
+	}
+	if s.cacheMaxAge, err = envDuration("CACHE_MAX_AGE", s.pollInterval); err != nil {
+		return nil, err
+	}
+	if s.cacheMaxAge < 0 {
+		return nil, fmt.Errorf("invalid CACHE_MAX_AGE: must not be negative")
+	}
+	if s.serveStale, err = envBool("SERVE_STALE", false); err != nil {
+		return nil, err
+	}
+	if s.maxStale, err = envDuration("MAX_STALE", 0); err != nil {
+		return nil, err
+	}
+	if s.maxStale < 0 {
+		return nil, fmt.Errorf("invalid MAX_STALE: must not be negative")
+	}
+	if s.healthMaxAge, err = envDuration("HEALTH_MAX_AGE", 0); err != nil {
+		return nil, err
+	}
+	if s.healthMaxAge < 0 {
+		return nil, fmt.Errorf("invalid HEALTH_MAX_AGE: must not be negative")
+	}
+	if raw := getenv("UPSTREAM_RATE_PER_MINUTE"); raw != "" {
+		s.upstreamRate, err = strconv.ParseFloat(raw, 64)
+		if err != nil || s.upstreamRate <= 0 || math.IsInf(s.upstreamRate, 0) {
+			return nil, fmt.Errorf("invalid UPSTREAM_RATE_PER_MINUTE %q: expected a positive number", raw)
+		}
+		burst, err := envInt64("UPSTREAM_BURST", 1)
+		if err != nil {
+			return nil, err
+		}
+		if burst < 1 || burst > 1000 {
+			return nil, fmt.Errorf("invalid UPSTREAM_BURST: expected 1 to 1000")
+		}
+		s.upstreamBurst = int(burst)
+	} else if getenv("UPSTREAM_BURST") != "" {
+		return nil, fmt.Errorf("invalid UPSTREAM_BURST: only applies with UPSTREAM_RATE_PER_MINUTE")
+	}
+	return s, nil
+}
+
+// watchReloads reloads configFile on every SIGHUP until ctx is done.
+// Without a config file SIGHUP keeps its default of stopping the server.
+func watchReloads(ctx context.Context) {
+	if configFile == "" {
+		return
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			if err := reloadConfig(); err != nil {
+				slog.Error("Config reload failed; keeping the current settings", "error", err)
+			}
+		}
+	}
+}
+
+// reloadConfig re-reads configFile and applies the reloadable settings,
+// all or none. Changes to any other setting are logged as ignored, by name
+// only since some are secrets, and take effect at the next restart.
+func reloadConfig() error {
+	values, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	previous := configValues
+	configValues = values
+	next, err := loadReloadable()
+	if err != nil {
+		configValues = previous
+		return err
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	for name := range previous {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		old, hadOld := previous[name]
+		value, hasValue := values[name]
+		if slices.Contains(reloadableNames, name) || (old == value && hadOld == hasValue) {
+			continue
+		}
+		slog.Warn("Ignoring config change that needs a restart", "name", name)
+		if hadOld {
+			configValues[name] = old
+		} else {
+			delete(configValues, name)
+		}
+	}
+
+	current := settings()
+	if (next.pollInterval > 0) != (current.pollInterval > 0) {
+		slog.Warn("Ignoring POLL_INTERVAL change: turning polling on or off needs a restart")
+		next.pollInterval = current.pollInterval
+		if getenv("CACHE_MAX_AGE") == "" {
+			next.cacheMaxAge = current.pollInterval
+		}
+	}
+	if (next.upstreamRate > 0) != (current.upstreamRate > 0) {
+		slog.Warn("Ignoring UPSTREAM_RATE_PER_MINUTE change: turning the upstream quota on or off needs a restart")
+		next.upstreamRate, next.upstreamBurst = current.upstreamRate, current.upstreamBurst
+	}
+
+	// Log at the lower of the two levels, so the changes show up whether
+	// the level is being raised or lowered.
+	logLevel.Set(min(current.logLevel, next.logLevel))
+	logChanged("LOG_LEVEL", current.logLevel, next.logLevel)
+	logChanged("POLL_INTERVAL", current.pollInterval, next.pollInterval)
+	logChanged("CACHE_MAX_AGE", current.cacheMaxAge, next.cacheMaxAge)
+	logChanged("SERVE_STALE", current.serveStale, next.serveStale)
+	logChanged("MAX_STALE", current.maxStale, next.maxStale)
+	logChanged("HEALTH_MAX_AGE", current.healthMaxAge, next.healthMaxAge)
+	logChanged("UPSTREAM_RATE_PER_MINUTE", current.upstreamRate, next.upstreamRate)
+	logChanged("UPSTREAM_BURST", current.upstreamBurst, next.upstreamBurst)
+	slog.Info("Config reloaded", "file", configFile)
+	applySettings(next)
+	return nil
+}
+
+// applySettings puts s in effect, including where a setting lives outside
+// reloadableSettings: the log level, the poller's ticker and the upstream
+// quota's bucket.
+func applySettings(s *reloadableSettings) {
+	liveSettings.Store(s)
+	logLevel.Set(s.logLevel)
+	if quotePoller != nil {
+		quotePoller.setInterval(s.pollInterval)
+	}
+	if upstreamQuota != nil {
+		upstreamQuota.setLimits(s.upstreamRate, s.upstreamBurst)
+	}
+}
+
+func logChanged[T comparable](name string, old, new T) {
+	if old != new {
+		slog.Info("Setting changed", "name", name, "from", old, "to", new)
+	}
+}
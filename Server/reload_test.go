@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reloadWith writes lines to a fresh CONFIG_FILE and reloads it, putting
+// the previous config back at cleanup.
+func reloadWith(t *testing.T, lines string) error {
+	t.Helper()
+	previousFile, previousValues, previousSettings := configFile, configValues, settings()
+	t.Cleanup(func() {
+		configFile, configValues = previousFile, previousValues
+		applySettings(previousSettings)
+	})
+	configFile = filepath.Join(t.TempDir(), "cotacao.env")
+	if err := os.WriteFile(configFile, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return reloadConfig()
+}
+
+func TestReloadChangesUpstreamQuota(t *testing.T) {
+	withClock(t, time.Unix(1715952600, 0))
+	withSettings(t, func(s *reloadableSettings) { s.upstreamRate, s.upstreamBurst = 60, 1 })
+	previous := upstreamQuota
+	upstreamQuota = newTokenBucket(60, 1)
+	t.Cleanup(func() { upstreamQuota = previous })
+
+	if err := reloadWith(t, "UPSTREAM_RATE_PER_MINUTE=120\nUPSTREAM_BURST=5\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := settings(); got.upstreamRate != 120 || got.upstreamBurst != 5 {
+		t.Errorf("settings at %v/min, burst %d, want 120/min, burst 5", got.upstreamRate, got.upstreamBurst)
+	}
+	if upstreamQuota.rate != 2 || upstreamQuota.burst != 5 {
+		t.Errorf("bucket at %v/s, burst %v, want 2/s, burst 5", upstreamQuota.rate, upstreamQuota.burst)
+	}
+	if got := upstreamQuota.remaining(); got != 1 {
+		t.Errorf("%d tokens left, want the 1 saved before the reload", got)
+	}
+}
+
+func TestReloadCannotTurnUpstreamQuotaOnOrOff(t *testing.T) {
+	withSettings(t, func(s *reloadableSettings) { s.upstreamRate, s.upstreamBurst = 0, 0 })
+	previous := upstreamQuota
+	upstreamQuota = nil
+	t.Cleanup(func() { upstreamQuota = previous })
+
+	if err := reloadWith(t, "UPSTREAM_RATE_PER_MINUTE=30\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := settings().upstreamRate; got != 0 || upstreamQuota != nil {
+		t.Errorf("quota turned on at %v/min by a reload, want it left off until a restart", got)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// readDSN is DB_READ_DSN: a read-only copy of the database, such as a
+// replicated file opened with mode=ro, that the reporting endpoints query
+// so they don't compete with quote writes. Empty means they read the
+// primary like everything else.
+var readDSN string
+
+// openReadDB is openQuotesDB for reporting queries. It connects to readDSN
+// when one is set, without touching the schema, which is the primary's to
+// manage.
+func openReadDB(w http.ResponseWriter) *sql.DB {
+	if readDSN == "" {
+		return openQuotesDB(w)
+	}
+	db, err := sql.Open("sqlite", readDSN)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Failed to open read replica: %v", err),
+			http.StatusInternalServerError,
+		)
+		return nil
+	}
+	return db
+}
+
+// loadRecentQuotes loads recentQuotes for a reporting request reading from
+// db. The buffer mirrors the primary, which stores are added to as they
+// happen, so with a replica it is loaded from the primary instead, since
+// the replica may lag behind.
+func loadRecentQuotes(db *sql.DB) {
+	if readDSN == "" {
+		recentQuotes.ensureLoaded(db)
+		return
+	}
+	if recentQuotes.isLoaded() {
+		return
+	}
+	primary, err := openDB()
+	if err != nil {
+		slog.Warn("Could not load recent quotes", "error", err)
+		return
+	}
+	defer primary.Close()
+	recentQuotes.ensureLoaded(primary)
+}
+
+// checkReadReplica makes sure at startup that readDSN can be queried, so a
+// wrong DSN is reported once instead of as a 500 on every report.
+func checkReadReplica() error {
+	db, err := sql.Open("sqlite", readDSN)
+	if err != nil {
+		return fmt.Errorf("error connecting to read replica: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutProbe)
+	defer cancel()
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM quotes)").Scan(&exists); err != nil {
+		return fmt.Errorf("error querying read replica: %v", err)
+	}
+	return nil
+}
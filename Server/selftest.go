@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type SelfTestResponse struct {
+	OK        bool   `json:"ok"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// selfTestHandler runs the full upstream fetch and parse for a pair without
+// storing or returning the quote, so a deployment can be smoke tested
+// without touching the database.
+func selfTestHandler(w http.ResponseWriter, r *http.Request) {
+	pair, ok := pairParam(w, r)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	_, err := fetchQuote(r.Context(), pair)
+	response := SelfTestResponse{
+		OK:        err == nil,
+		LatencyMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		response.Error = err.Error()
+		writeJSONStatus(w, upstreamStatus(err), response)
+		return
+	}
+	writeJSON(w, response)
+}
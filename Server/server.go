@@ -1,204 +1,1251 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	_ "github.com/glebarez/go-sqlite"
+	sqlite "github.com/glebarez/go-sqlite"
+	"golang.org/x/sync/singleflight"
 )
 
+// dbPath is the SQLite file quotes are stored in.
+var dbPath = "../dollarQuotation.db"
+
+const (
+	// createDateLayout is how awesomeapi writes create_date, in
+	// upstreamLocation's local time.
+	createDateLayout = "2006-01-02 15:04:05"
+
+	timeoutAPI   = 200 * time.Millisecond
+	timeoutDB    = 10 * time.Millisecond
+	timeoutQuery = 500 * time.Millisecond
+	timeoutProbe = time.Second
+
+	shutdownTimeout = 10 * time.Second
+
+	dbWaitBackoff    = 100 * time.Millisecond
+	dbWaitMaxBackoff = 5 * time.Second
+
+	insertRetries = 3
+	insertBackoff = time.Millisecond
+
+	schemaRetries = 5
+	schemaBackoff = 10 * time.Millisecond
+)
+
+// SQLite primary result codes; extended codes keep these in the low byte.
 const (
-	requestURL = "https://economia.awesomeapi.com.br/json/last/USD-BRL"
-	timeoutAPI = 200 * time.Millisecond
-	timeoutDB  = 10 * time.Millisecond
+	sqliteBusy   = 5
+	sqliteLocked = 6
 )
 
+//go:embed openapi.json
+var openAPISpec []byte
+
 type Quote struct {
 	Bid        float64   `json:"bid"`
 	Timestamp  int64     `json:"timestamp"`
 	CreateDate time.Time `json:"create_date"`
+
+	// The day's movement as the provider reports it, nil when it didn't.
+	VarBid    *float64 `json:"var_bid,omitempty"`
+	PctChange *float64 `json:"pct_change,omitempty"`
+	High      *float64 `json:"high,omitempty"`
+	Low       *float64 `json:"low,omitempty"`
+
+	// Backfilled marks a row /admin/backfill carried forward into a gap
+	// rather than one the provider quoted.
+	Backfilled bool `json:"backfilled,omitempty"`
+}
+
+// upstreamError marks a failure caused by the quote provider rather than by
+// this server, along with the gateway status the handler should answer with.
+type upstreamError struct {
+	status    int
+	retryable bool
+	err       error
+}
+
+func (e *upstreamError) Error() string { return e.err.Error() }
+func (e *upstreamError) Unwrap() error { return e.err }
+
+func badUpstream(err error) error {
+	return &upstreamError{status: http.StatusBadGateway, err: err}
+}
+
+// retryableUpstream marks a transient provider failure, such as a response
+// cut short, that is worth fetching again.
+func retryableUpstream(err error) error {
+	return &upstreamError{status: http.StatusBadGateway, retryable: true, err: err}
+}
+
+func isRetryable(err error) bool {
+	var upErr *upstreamError
+	return errors.As(err, &upErr) && upErr.retryable
+}
+
+// errPairNotFound is what the provider's 404 means: it doesn't quote one of
+// the requested pairs.
+var errPairNotFound = errors.New("pair not found upstream")
+
+// unknownPair reports a pair the provider doesn't quote. It is the caller's
+// mistake, so it is answered with a 400 rather than a 5xx.
+func unknownPair(pair string) error {
+	return &upstreamError{status: http.StatusBadRequest, err: fmt.Errorf("unknown currency pair %s", pair)}
+}
+
+func unavailableUpstream(err error) error {
+	return &upstreamError{status: http.StatusServiceUnavailable, err: err}
+}
+
+// isUnreachable reports whether err means the provider couldn't be reached
+// at all, as opposed to answering badly.
+func isUnreachable(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// upstreamDown reports whether a fetch failed because the provider is
+// unreachable, timed out or answered badly, rather than because of the
+// request.
+func upstreamDown(err error) bool {
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		return upErr.status >= http.StatusInternalServerError
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// upstreamStatus picks the response status for a failed quotation fetch.
+func upstreamStatus(err error) int {
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		return upErr.status
+	}
+	return http.StatusInternalServerError
 }
 
+// Quote sources, telling callers how fresh a /cotacao answer is: fetched
+// from the provider for this request, shared from a fetch another request
+// had in flight, or read back from the database.
+const (
+	quoteSourceLive  = "live"
+	quoteSourceCache = "cache"
+	quoteSourceDB    = "db"
+)
+
 type ClientResponse struct {
-	Bid float64 `json:"bid"`
+	Bid    float64 `json:"bid"`
+	Source string  `json:"source"`
+
+	// Only set when a stored quote stands in for an unreachable provider.
+	Stale      bool   `json:"stale,omitempty"`
+	AgeSeconds *int64 `json:"age_seconds,omitempty"`
+
+	// Only filled in for verbose=true.
+	VarBid     *float64   `json:"var_bid,omitempty"`
+	PctChange  *float64   `json:"pct_change,omitempty"`
+	High       *float64   `json:"high,omitempty"`
+	Low        *float64   `json:"low,omitempty"`
+	CreateDate *time.Time `json:"create_date,omitempty"`
+
+	// Only filled in for timing=true.
+	ServerProcessingMs *float64 `json:"server_processing_ms,omitempty"`
+
+	// pair picks the precision the bid is written with.
+	pair string
+}
+
+// clientResponseFields has ClientResponse's fields without its methods.
+type clientResponseFields ClientResponse
+
+// responseBidField is the key the bid is written under in ClientResponse,
+// for consumers that expect another name, such as rate.
+var responseBidField = "bid"
+
+var responseFieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// checkResponseBidField rejects a RESPONSE_BID_FIELD that isn't a plain
+// identifier or that clashes with another ClientResponse key.
+func checkResponseBidField(name string) error {
+	if !responseFieldPattern.MatchString(name) {
+		return fmt.Errorf("invalid RESPONSE_BID_FIELD %q: expected letters, digits and underscores", name)
+	}
+	fields := reflect.TypeOf(ClientResponse{})
+	for i := range fields.NumField() {
+		key, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ",")
+		if key == name && fields.Field(i).Name != "Bid" {
+			return fmt.Errorf("invalid RESPONSE_BID_FIELD %q: already used by the response", name)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes the bid with exactly the pair's precision, four
+// decimals by default, rather than the shortest float form (5.1 or
+// 5.12000000001), under responseBidField. It stays a JSON number and
+// comes first.
+func (r ClientResponse) MarshalJSON() ([]byte, error) {
+	rest, err := json.Marshal(struct {
+		// Bid hides the embedded field; the bid is written below.
+		Bid *struct{} `json:"bid,omitempty"`
+		clientResponseFields
+	}{
+		clientResponseFields: clientResponseFields(r),
+	})
+	if err != nil {
+		return nil, err
+	}
+	key, err := json.Marshal(responseBidField)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{'{'}, key...)
+	out = append(out, ':')
+	out = append(out, formatBid(r.Bid, r.pair)...)
+	if len(rest) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, rest[1:]...), nil
 }
 
 func main() {
+	if err := loadConfigFile(); err != nil {
+		log.Fatal(err)
+	}
+	closeLog, err := setupLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeLog()
+
+	if err := loadConfig(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if persist {
+		if err := waitForDatabase(dbWaitTimeout); err != nil {
+			slog.Error("Database unavailable", "error", err)
+			os.Exit(1)
+		}
+		if err := recoverIfCorrupt(); err != nil {
+			slog.Error("Database unusable; not starting", "error", err)
+			os.Exit(1)
+		}
+		if err := checkDatabase(); err != nil {
+			slog.Error("Database unavailable", "error", err)
+			os.Exit(1)
+		}
+		if err := prepareMovementColumns(); err != nil {
+			slog.Error("Could not add the movement columns", "error", err)
+			os.Exit(1)
+		}
+		if err := preparePairColumn(); err != nil {
+			slog.Error("Could not add the pair column", "error", err)
+			os.Exit(1)
+		}
+		if err := prepareBackfilledColumn(); err != nil {
+			slog.Error("Could not add the backfilled column", "error", err)
+			os.Exit(1)
+		}
+		if readDSN != "" {
+			if err := checkReadReplica(); err != nil {
+				slog.Error("Read replica unavailable", "error", err)
+				os.Exit(1)
+			}
+			slog.Info("Reporting queries read from the replica")
+		}
+	} else {
+		slog.Info("Persistence disabled; quotes are passed through without being stored")
+	}
+	if dayIndexEnabled {
+		if err := prepareDayIndex(); err != nil {
+			slog.Error("Could not set up the day index", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	warmCaches(ctx)
+	if retentionDays > 0 {
+		go runRetention(ctx)
+	}
+
+	if interval := settings().pollInterval; interval > 0 {
+		quotePoller = newPoller(interval, nil)
+		go quotePoller.run()
+		slog.Info("Polling quotations in the background", "interval", interval)
+	} else if pollSchedule != nil {
+		quotePoller = newPoller(0, pollSchedule)
+		go quotePoller.run()
+		slog.Info(
+			"Polling quotations in the background on a schedule",
+			"cron", getenv("POLL_CRON"),
+			"next", pollSchedule.Next(time.Now()),
+		)
+	}
+	go watchReloads(ctx)
+
+	if pprofEnabled {
+		go servePprof()
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/cotacao", getDollarQuotationHandler)
-	http.ListenAndServe(":8080", mux)
+	mux.HandleFunc("POST /cotacao/import", requirePersistence(importQuotesHandler))
+	mux.HandleFunc("GET /cotacao/history", requirePersistence(getHistoryHandler))
+	mux.HandleFunc("GET /cotacao/ohlc", requirePersistence(getOHLCHandler))
+	mux.HandleFunc("GET /cotacao/at", requirePersistence(getQuoteAtHandler))
+	mux.HandleFunc("GET /cotacao/first", requirePersistence(getFirstQuoteHandler))
+	mux.HandleFunc("GET /cotacao/selftest", selfTestHandler)
+	mux.HandleFunc("GET /cotacao/compare", compareHandler)
+	mux.HandleFunc("GET /cotacao/multi", multiQuoteHandler)
+	mux.HandleFunc("GET /cotacao/wait", waitQuoteHandler)
+	mux.HandleFunc("GET /cotacao/events", requirePersistence(getEventsHandler))
+	mux.HandleFunc("GET /health", healthHandler)
+	mux.HandleFunc("GET /version", versionHandler)
+	mux.HandleFunc("GET /stats/internal", internalStatsHandler)
+	mux.Handle("GET /metrics", metricsHandler)
+	mux.HandleFunc("POST /admin/refresh", requireAdmin(adminRefreshHandler))
+	mux.HandleFunc("GET /admin/export", requireAdmin(requirePersistence(exportHandler)))
+	mux.HandleFunc("POST /admin/backfill", requireAdmin(requirePersistence(backfillHandler)))
+	mux.HandleFunc("GET /openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /{$}", dashboardHandler)
+
+	srv := &http.Server{Addr: ":8080", Handler: accessLog(cors(withRequestTimeout(limitRequestBody(mux))))}
+	srv.RegisterOnShutdown(quoteUpdates.stop)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-serveErr:
+		slog.Error("server stopped", "error", err)
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	}
+	shutdown(srv)
+}
+
+// shutdown stops accepting requests and lets in-flight ones finish before
+// draining the poller, since /admin/refresh requests wait on it. Quote
+// writes that outlived both, from requests past shutdownTimeout or a poll
+// past pollDrainTimeout, get writeDrainTimeout more, and new ones are
+// refused. Each write closes its own database handle, so nothing is left
+// to close after that.
+func shutdown(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("HTTP shutdown did not complete", "error", err)
+	}
+
+	if quotePoller != nil && !quotePoller.stop(pollDrainTimeout) {
+		slog.Warn("Poller did not finish within the drain timeout", "timeout", pollDrainTimeout)
+	}
+
+	flushed, dropped := quoteWrites.drain(writeDrainTimeout)
+	switch {
+	case dropped > 0:
+		slog.Error("Quote writes did not finish before exiting", "flushed", flushed, "dropped", dropped)
+	case flushed > 0:
+		slog.Info("Flushed quote writes", "flushed", flushed)
+	}
 }
 
 func connectDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite", "../dollarQuotation.db")
+	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to database: %v", err)
 	}
 	return db, nil
 }
 
+// ensureQuoteExists creates the schema unless it is already there. Several
+// instances may start against the same file at once, so the tables are
+// created together in one transaction, retried while another instance holds
+// the write lock; the common case, where they exist, only reads.
 func ensureQuoteExists(db *sql.DB) error {
-	createTableSQL := `
+	var tables int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('quotes', 'idempotency_keys', 'quote_events')",
+	).Scan(&tables)
+	if err == nil && tables == 3 {
+		return nil
+	}
+
+	return retrySchema(db, createSchema)
+}
+
+// retrySchema runs a schema change, retrying it from the start while
+// another instance holds the write lock. A change that lost the race then
+// finds the schema in place.
+func retrySchema(db *sql.DB, change func(*sql.DB) error) error {
+	err := change(db)
+	for attempt := 0; attempt < schemaRetries && isBusyError(err); attempt++ {
+		time.Sleep(schemaBackoff << attempt)
+		err = change(db)
+	}
+	return err
+}
+
+// execer is satisfied by *sql.DB, *sql.Tx and *sql.Conn.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// inWriteTx runs change on one connection inside BEGIN IMMEDIATE, so it
+// holds the write lock, and sees the current schema, from its first
+// statement.
+func inWriteTx(ctx context.Context, db *sql.DB, change func(ctx context.Context, conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting database connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("error starting write transaction: %w", err)
+	}
+	// ROLLBACK must run even once ctx is done, or the connection would go
+	// back to the pool still inside the transaction.
+	if err := change(ctx, conn); err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return fmt.Errorf("error committing write transaction: %w", err)
+	}
+	return nil
+}
+
+func createSchema(db *sql.DB) error {
+	return inWriteTx(context.Background(), db, func(ctx context.Context, conn *sql.Conn) error {
+		createTableSQL := `
     CREATE TABLE IF NOT EXISTS quotes (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
+        pair TEXT NOT NULL DEFAULT '` + defaultPair + `',
         bid DECIMAL(10, 4) NOT NULL,
         timestamp BIGINT NOT NULL,
-        create_date DATETIME NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+        create_date DATETIME NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+        var_bid DECIMAL(10, 4),
+        pct_change DECIMAL(10, 4),
+        high DECIMAL(10, 4),
+        low DECIMAL(10, 4),
+        backfilled INTEGER NOT NULL DEFAULT 0
     );`
 
-	_, err := db.Exec(createTableSQL)
+		if _, err := conn.ExecContext(ctx, createTableSQL); err != nil {
+			return fmt.Errorf("error creating quotes table: %w", err)
+		}
+		if err := ensureIdempotencyKeysExist(ctx, conn); err != nil {
+			return err
+		}
+		return ensureQuoteEventsExist(ctx, conn)
+	})
+}
+
+// upstreamFlights coalesces concurrent fetches of the same pair, so a burst
+// of requests costs the provider one call.
+var upstreamFlights singleflight.Group
+
+// fetchQuote gets the current quote for pair, sharing the result of a fetch
+// already in flight for it. The shared fetch keeps the deadline of the
+// caller that started it but not its cancellation, so one caller giving up
+// doesn't fail the rest; each caller still stops waiting when its own ctx
+// is done. A panic in the fetch fails it rather than the process, which
+// singleflight would otherwise crash by re-raising it on its own goroutine.
+func fetchQuote(ctx context.Context, pair string) (*Quote, error) {
+	quote, _, err := fetchSharedQuote(ctx, pair)
+	return quote, err
+}
+
+// fetchSharedQuote is fetchQuote, also reporting whether the quote came
+// from a fetch another caller started rather than one made for this call.
+func fetchSharedQuote(ctx context.Context, pair string) (*Quote, bool, error) {
+	// Only set when this caller's function is the one that ran, which
+	// happens before its result is received.
+	fetched := false
+	results := upstreamFlights.DoChan(pair, func() (val interface{}, err error) {
+		fetched = true
+		defer func() {
+			if p := recover(); p != nil {
+				slog.Error("Upstream fetch panicked", "pair", pair, "panic", p, "stack", string(debug.Stack()))
+				val, err = nil, fmt.Errorf("upstream fetch panicked: %v", p)
+			}
+		}()
+		shared := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			shared, cancel = context.WithDeadline(shared, deadline)
+			defer cancel()
+		}
+		return fetchQuoteOnce(shared, pair)
+	})
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, !fetched, result.Err
+		}
+		return result.Val.(*Quote), !fetched, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// fetchQuoteOnce fetches pair's quote, fetching again up to upstreamRetries
+// times when the provider fails in a retryable way.
+func fetchQuoteOnce(ctx context.Context, pair string) (*Quote, error) {
+	quote, err := getDollarQuotation(ctx, pair)
+	for attempt := 1; attempt <= upstreamRetries && isRetryable(err); attempt++ {
+		slog.Warn("Retrying upstream fetch", "pair", pair, "attempt", attempt, "error", err)
+		quote, err = getDollarQuotation(ctx, pair)
+	}
+	return quote, err
+}
+
+// fetchQuotes gets the current quotes for pairs, failing as a whole when
+// any of them can't be had.
+func fetchQuotes(ctx context.Context, pairs []string) (map[string]*Quote, error) {
+	quotes, failed, err := fetchSomeQuotes(ctx, pairs)
 	if err != nil {
-		return fmt.Errorf("error creating quotes table: %v", err)
+		return nil, err
 	}
+	for _, pair := range pairs {
+		if err := failed[pair]; err != nil {
+			return nil, err
+		}
+	}
+	return quotes, nil
+}
 
-	return nil
+// fetchSomeQuotes gets the current quotes for pairs. Pairs served by the
+// default provider are fetched together in one upstream request; pairs
+// mapped in PAIR_PROVIDERS are fetched from their own URL. A pair that
+// can't be had, because its provider failed or left it out of the batch,
+// is reported in failed while the others are still returned; only a pair
+// the provider doesn't know fails the whole call, being the caller's
+// mistake.
+func fetchSomeQuotes(ctx context.Context, pairs []string) (quotes map[string]*Quote, failed map[string]error, err error) {
+	quotes = make(map[string]*Quote, len(pairs))
+	failed = map[string]error{}
+	var batch []string
+	for _, pair := range pairs {
+		if _, custom := pairURLs[pair]; !custom {
+			if !slices.Contains(batch, pair) {
+				batch = append(batch, pair)
+			}
+			continue
+		}
+		quote, err := fetchQuote(ctx, pair)
+		if upstreamStatus(err) == http.StatusBadRequest {
+			return nil, nil, err
+		}
+		if err != nil {
+			failed[pair] = err
+			continue
+		}
+		quotes[pair] = quote
+	}
+	if len(batch) == 0 {
+		return quotes, failed, nil
+	}
+
+	batchURL := defaultProviderURL + strings.Join(batch, ",")
+	data, err := getUpstream(ctx, batchURL)
+	for attempt := 1; attempt <= upstreamRetries && isRetryable(err); attempt++ {
+		slog.Warn("Retrying upstream fetch", "pairs", batch, "attempt", attempt, "error", err)
+		data, err = getUpstream(ctx, batchURL)
+	}
+	if errors.Is(err, errPairNotFound) && len(batch) > 1 {
+		// The provider rejects the whole batch without saying which pair
+		// it doesn't know, so ask for each one to name it.
+		for _, pair := range batch {
+			if _, err := fetchQuote(ctx, pair); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if errors.Is(err, errPairNotFound) {
+		return nil, nil, unknownPair(strings.Join(batch, ","))
+	}
+	if err != nil {
+		for _, pair := range batch {
+			failed[pair] = err
+		}
+		return quotes, failed, nil
+	}
+
+	for _, pair := range batch {
+		quote, err := parseQuote(data, pair)
+		if err != nil {
+			failed[pair] = err
+			continue
+		}
+		quotes[pair] = quote
+	}
+	return quotes, failed, nil
+}
+
+func getDollarQuotation(ctx context.Context, pair string) (*Quote, error) {
+	data, err := getUpstream(ctx, providerURL(pair))
+	if errors.Is(err, errPairNotFound) {
+		return nil, unknownPair(pair)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseQuote(data, pair)
 }
 
-func getDollarQuotation() (*Quote, error) {
-	ctxAPI, cancelAPI := context.WithTimeout(context.Background(), timeoutAPI)
+// getUpstream requests url from the provider and decodes its JSON body,
+// within upstreamShare of ctx's remaining time, which includes waiting for
+// one of upstreamSlots.
+func getUpstream(ctx context.Context, url string) (map[string]interface{}, error) {
+	ctxAPI, cancelAPI := stageContext(ctx, upstreamShare, timeoutAPI)
 	defer cancelAPI()
 
-	req, err := http.NewRequestWithContext(ctxAPI, "GET", requestURL, nil)
+	req, err := http.NewRequestWithContext(ctxAPI, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
+	if upstreamAPIKey != "" && strings.HasPrefix(url, defaultProviderURL) {
+		req.Header.Set("x-api-key", upstreamAPIKey)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	if err := takeUpstreamQuota(ctxAPI); err != nil {
+		return nil, err
+	}
+	release, err := acquireUpstream(ctxAPI)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
+		return nil, err
+	}
+	defer release()
+	servedCounters.upstreamFetches.Add(1)
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errUpstreamRedirect) {
+			return nil, badUpstream(err)
+		}
+		if isUnreachable(err) {
+			return nil, unavailableUpstream(fmt.Errorf("cannot reach quote provider; check network: %w", err))
+		}
+		// With time left on the request, a timeout is upstreamClient's dial
+		// or TLS handshake timeout.
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && ctxAPI.Err() == nil {
+			return nil, unavailableUpstream(fmt.Errorf("cannot connect to quote provider in time: %w", err))
+		}
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUpstreamBody+1))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, retryableUpstream(fmt.Errorf("truncated upstream response: %w", err))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
+	if int64(len(body)) > maxUpstreamBody {
+		return nil, badUpstream(fmt.Errorf("upstream response exceeds %d bytes", maxUpstreamBody))
+	}
+	if len(bytes.TrimSpace(body)) == 0 && resp.StatusCode < 400 {
+		return nil, retryableUpstream(errors.New("empty upstream response"))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errPairNotFound
+	}
+	if resp.StatusCode >= 400 {
+		return nil, badUpstream(fmt.Errorf("upstream returned status %d", resp.StatusCode))
+	}
 
 	var data map[string]interface{}
 	if err = json.Unmarshal(body, &data); err != nil {
 		return nil, fmt.Errorf("error decoding JSON: %v", err)
 	}
+	return data, nil
+}
 
-	rate := data["USDBRL"].(map[string]interface{})
-	bid, err := strconv.ParseFloat(rate["bid"].(string), 64)
+// parseQuote reads pair's quote out of a decoded provider response.
+func parseQuote(data map[string]interface{}, pair string) (*Quote, error) {
+	rate, ok := data[pairKey(pair)].(map[string]interface{})
+	if !ok {
+		return nil, missingPair(data, pair)
+	}
+	code, _ := rate["code"].(string)
+	codein, _ := rate["codein"].(string)
+	if code != "" && codein != "" && code+"-"+codein != pair {
+		return nil, badUpstream(fmt.Errorf(
+			"upstream returned wrong pair: asked for %s, got %s-%s under %s",
+			pair, code, codein, pairKey(pair),
+		))
+	}
+	rawBid, ok := rate["bid"].(string)
+	if !ok {
+		return nil, badUpstream(fmt.Errorf("upstream bid is %s, not a string", jsonKind(rate["bid"])))
+	}
+	bidStr := strings.TrimSpace(rawBid)
+	if bidStr == "" {
+		return nil, badUpstream(errors.New("upstream returned empty bid"))
+	}
+	bid, err := strconv.ParseFloat(bidStr, 64)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing bid: %v", err)
+		return nil, badUpstream(fmt.Errorf("error parsing bid: %v", err))
 	}
+	checkPrecision(pair, bidStr, bid)
 
-	timestampStr := rate["timestamp"].(string)
-	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	timestampStr, _ := rate["timestamp"].(string)
+	timestamp, err := parseTimestamp(timestampStr)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing timestamp: %v", err)
+		return nil, err
 	}
 
-	createDateStr := rate["create_date"].(string)
-	createDate, err := time.Parse("2006-01-02 15:04:05", createDateStr)
+	createDateStr, ok := rate["create_date"].(string)
+	if !ok {
+		return nil, badUpstream(fmt.Errorf("upstream create_date is %s, not a string", jsonKind(rate["create_date"])))
+	}
+	createDate, err := time.ParseInLocation(createDateLayout, createDateStr, upstreamLocation)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing create_date: %v", err)
+		return nil, badUpstream(fmt.Errorf("error parsing create_date: %v", err))
 	}
 
 	quote := &Quote{
 		Bid:        bid,
 		Timestamp:  timestamp,
-		CreateDate: createDate,
+		CreateDate: createDate.UTC(),
+		VarBid:     upstreamFloat(rate, "varBid"),
+		PctChange:  upstreamFloat(rate, "pctChange"),
+		High:       upstreamFloat(rate, "high"),
+		Low:        upstreamFloat(rate, "low"),
 	}
 	return quote, nil
 }
 
-func saveIfTimestampChanged(db *sql.DB, newQuote *Quote) error {
-	ctxDB, cancelDB := context.WithTimeout(context.Background(), timeoutDB)
-	defer cancelDB()
+// jsonKind names the JSON type of a decoded value, for errors about provider
+// fields of the wrong type.
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "missing or null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
 
-	var currentTimestamp int64
-	err := db.QueryRowContext(ctxDB, "SELECT timestamp FROM quotes ORDER BY id DESC LIMIT 1").
-		Scan(&currentTimestamp)
-	switch {
-	case err == sql.ErrNoRows:
-		return insertQuote(ctxDB, db, newQuote)
-	case err != nil:
-		return fmt.Errorf("error querying database: %v", err)
+// missingPair is the error for a response without pair's quote. Naming
+// the quotes it holds instead tells a misrouted or buggy provider apart
+// from an answer with no quote at all.
+func missingPair(data map[string]interface{}, pair string) error {
+	var got []string
+	for key, value := range data {
+		if _, ok := value.(map[string]interface{}); ok {
+			got = append(got, key)
+		}
 	}
+	if len(got) == 0 {
+		return badUpstream(fmt.Errorf("upstream response has no %s quote", pair))
+	}
+	slices.Sort(got)
+	return badUpstream(fmt.Errorf(
+		"upstream returned wrong pair: asked for %s, got %s",
+		pairKey(pair), strings.Join(got, ", "),
+	))
+}
 
-	if newQuote.Timestamp != currentTimestamp {
-		return insertQuote(ctxDB, db, newQuote)
+// parseTimestamp reads the provider's timestamp, in Unix seconds. A value
+// that isn't one, such as digits overflowing int64, is the provider's fault
+// and answered as a bad upstream response naming the value.
+func parseTimestamp(raw string) (int64, error) {
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, badUpstream(fmt.Errorf("upstream timestamp %q is out of range for Unix seconds", raw))
 	}
+	if err != nil {
+		return 0, badUpstream(fmt.Errorf("upstream timestamp %q is not an integer", raw))
+	}
+	return timestamp, nil
+}
 
+// persistQuote opens the database and stores quote according to storeMode,
+// unless lastStored shows the database already has it.
+func persistQuote(ctx context.Context, pair string, quote *Quote) error {
+	if !persist || alreadyStored(pair, quote) {
+		return nil
+	}
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := saveQuote(ctx, db, pair, quote); err != nil {
+		return err
+	}
+	rememberStored(pair, quote)
 	return nil
 }
 
-func insertQuote(ctx context.Context, db *sql.DB, quote *Quote) error {
-	_, err := db.ExecContext(
-		ctx,
-		"INSERT INTO quotes (bid, timestamp, create_date) VALUES (?, ?, ?)",
-		quote.Bid,
-		quote.Timestamp,
-		quote.CreateDate,
-	)
+// saveQuote stores a fetched quote according to storeMode, within the time
+// left on ctx.
+func saveQuote(ctx context.Context, db *sql.DB, pair string, quote *Quote) error {
+	if storeMode != storeAlways || minChange > 0 {
+		return saveIfChanged(ctx, db, pair, quote)
+	}
+
+	ctxDB, cancelDB := stageContext(ctx, 1, timeoutDB)
+	defer cancelDB()
+
+	stored := *quote
+	stored.Timestamp = clock().Unix()
+	return insertQuote(ctxDB, db, pair, &stored, nil)
+}
+
+// saveIfChanged stores newQuote unless quoteChanged finds it too close to
+// the newest stored quote. The check runs in the insert's transaction, so
+// concurrent saves can't both find the same old row and store one quote
+// twice.
+func saveIfChanged(ctx context.Context, db *sql.DB, pair string, newQuote *Quote) error {
+	ctxDB, cancelDB := stageContext(ctx, 1, timeoutDB)
+	defer cancelDB()
+
+	stored := *newQuote
+	if storeMode == storeAlways {
+		stored.Timestamp = clock().Unix()
+	}
+	return insertQuote(ctxDB, db, pair, &stored, func(current Quote) bool {
+		return quoteChanged(current, *newQuote)
+	})
+}
+
+// minChangeEpsilon absorbs float rounding, so a bid moving by exactly
+// MIN_CHANGE counts as not having moved more than it.
+const minChangeEpsilon = 1e-9
+
+// quoteChanged reports whether quote should be stored after current. By
+// default that's when the upstream timestamp changed; with MIN_CHANGE set,
+// it's when the bid moved by more than the threshold, whatever the
+// timestamp.
+func quoteChanged(current, quote Quote) bool {
+	if minChange == 0 {
+		return quote.Timestamp != current.Timestamp
+	}
+	threshold := minChange
+	if minChangePercent {
+		threshold = math.Abs(current.Bid) * minChange / 100
+	}
+	return math.Abs(quote.Bid-current.Bid)-threshold > minChangeEpsilon
+}
+
+// errSaveTimeout marks a quote that couldn't be stored within its time
+// budget, as opposed to one the database refused.
+var errSaveTimeout = errors.New("timed out")
+
+// insertQuote retries briefly when SQLite reports the database as busy or
+// locked, giving up early once ctx expires. Any other error fails at once.
+// Failing because ctx expired, whatever error SQLite gave for it, is
+// reported as errSaveTimeout.
+func insertQuote(ctx context.Context, db *sql.DB, pair string, quote *Quote, changed func(current Quote) bool) error {
+	if !quoteWrites.begin() {
+		return errWritesClosed
+	}
+	defer quoteWrites.end()
+
+	id, err := execInsertQuote(ctx, db, pair, quote, changed)
+	for attempt := 0; attempt < insertRetries && isBusyError(err) && ctx.Err() == nil; attempt++ {
+		select {
+		case <-ctx.Done():
+		case <-time.After(insertBackoff << attempt):
+			id, err = execInsertQuote(ctx, db, pair, quote, changed)
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("error inserting quote into database: %w: %v", errSaveTimeout, err)
+	}
 	if err != nil {
 		return fmt.Errorf("error inserting quote into database: %v", err)
 	}
-	fmt.Printf("Quote saved successfully\n")
+	if id == 0 {
+		return nil
+	}
+	recentQuotes.add(id, *quote)
+	recordStoredBid(pair, quote)
+	slog.Info("Quote saved successfully", "pair", pair, "timestamp", quote.Timestamp)
 	return nil
 }
 
-func getDollarQuotationHandler(w http.ResponseWriter, r *http.Request) {
-	quote, err := getDollarQuotation()
-	if err != nil {
-		http.Error(
-			w,
-			fmt.Sprintf("Failed to fetch quotation: %v", err),
-			http.StatusInternalServerError,
+// execInsertQuote inserts quote for pair and its event under the write
+// lock. When changed is set, pair's newest stored quote is read first under
+// the same lock, and quote is only inserted if changed approves of it;
+// otherwise the returned id is 0.
+func execInsertQuote(ctx context.Context, db *sql.DB, pair string, quote *Quote, changed func(current Quote) bool) (int64, error) {
+	var id int64
+	err := inWriteTx(ctx, db, func(ctx context.Context, conn *sql.Conn) error {
+		if changed != nil {
+			var current Quote
+			err := conn.QueryRowContext(
+				ctx,
+				"SELECT bid, timestamp FROM quotes WHERE pair = ? ORDER BY timestamp DESC, id DESC LIMIT 1",
+				pair,
+			).Scan(&current.Bid, &current.Timestamp)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+			case err != nil:
+				return fmt.Errorf("error querying database: %w", err)
+			case !changed(current):
+				return nil
+			}
+		}
+
+		result, err := conn.ExecContext(
+			ctx,
+			`INSERT INTO quotes (pair, bid, timestamp, create_date, var_bid, pct_change, high, low)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			append([]any{pair, quote.Bid, quote.Timestamp, quote.CreateDate}, quote.movementValues()...)...,
 		)
-		return
+		if err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		return appendEvent(ctx, conn, id, quote, providerURL(pair))
+	})
+	return id, err
+}
+
+func isBusyError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqliteBusy || code == sqliteLocked
+}
+
+// quoteETag identifies a quote by pair, upstream timestamp and bid, so it
+// changes exactly when the response body would.
+func quoteETag(pair string, quote *Quote) string {
+	return fmt.Sprintf(`"%s-%d-%s"`, pair, quote.Timestamp, strconv.FormatFloat(quote.Bid, 'f', -1, 64))
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
 	}
+	return false
+}
 
+// storeEmpty reports whether no quote has been stored yet. A database that
+// can't be read doesn't count as empty.
+func storeEmpty(ctx context.Context) bool {
+	if !persist {
+		return false
+	}
+	db, err := openDB()
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+
+	ctx, cancel := stageContext(ctx, 1, timeoutQuery)
+	defer cancel()
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM quotes WHERE pair = ?)", defaultPair).Scan(&exists)
+	return err == nil && !exists
+}
+
+// openDB connects to the database and makes sure the schema exists.
+func openDB() (*sql.DB, error) {
 	db, err := connectDB()
 	if err != nil {
-		http.Error(
-			w,
-			fmt.Sprintf("Failed to connect to database: %v", err),
-			http.StatusInternalServerError,
-		)
-		return
+		return nil, err
+	}
+	if err := ensureQuoteExists(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+var errDatabaseLocked = fmt.Errorf(
+	"database %s is locked by another process; stop the other instance or set DB_WAL=true",
+	dbPath,
+)
+
+// waitForDatabase pings the database until it answers, backing off between
+// attempts, so a server started alongside its database doesn't exit before
+// the database is up. It gives up once timeout has passed. A local SQLite
+// file answers on the first attempt.
+func waitForDatabase(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := dbWaitBackoff
+	for attempt := 1; ; attempt++ {
+		err := pingDatabase(context.Background())
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("error pinging database after %d attempts: %v", attempt, err)
+		}
+		slog.Warn("Database not ready, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, dbWaitMaxBackoff)
+	}
+}
+
+// checkDatabase runs at startup so a database held by another process is
+// reported once, clearly, instead of as a 500 on every request. It switches
+// the file to WAL when walEnabled is set and probes for the write lock; in
+// WAL mode a lock held elsewhere only earns a warning, since readers don't
+// block writers there and inserts retry while the database is busy.
+func checkDatabase() error {
+	db, err := openDB()
+	if err != nil {
+		if isBusyError(err) {
+			return errDatabaseLocked
+		}
+		return err
 	}
 	defer db.Close()
 
-	if err = ensureQuoteExists(db); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutProbe)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("error pinging database: %v", err)
+	}
+
+	journalMode := ""
+	if walEnabled {
+		err = db.QueryRowContext(ctx, "PRAGMA journal_mode=WAL").Scan(&journalMode)
+	} else {
+		err = db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode)
+	}
+	if err != nil && !isBusyError(err) {
+		return fmt.Errorf("error reading journal mode: %v", err)
+	}
+
+	err = probeWriteLock(ctx, db)
+	switch {
+	case err == nil:
+		return nil
+	case !isBusyError(err):
+		return fmt.Errorf("error probing database write lock: %v", err)
+	case strings.EqualFold(journalMode, "wal"):
+		slog.Warn("Database is locked by another process; continuing in WAL mode", "path", dbPath)
+		return nil
+	default:
+		return errDatabaseLocked
+	}
+}
+
+// probeWriteLock takes and releases SQLite's write lock without changing
+// anything.
+func probeWriteLock(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+// openQuotesDB is openDB for handlers: on failure it answers the request
+// with a 500 and returns nil.
+func openQuotesDB(w http.ResponseWriter) *sql.DB {
+	db, err := openDB()
+	if err != nil {
 		http.Error(
 			w,
-			fmt.Sprintf("Failed to create quotes table: %v", err),
+			fmt.Sprintf("Failed to open database: %v", err),
 			http.StatusInternalServerError,
 		)
+		return nil
+	}
+	return db
+}
+
+func getDollarQuotationHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	pair, ok := pairParam(w, r)
+	if !ok {
+		return
+	}
+	callback, ok := callbackParam(w, r)
+	if !ok {
 		return
 	}
 
-	if err = saveIfTimestampChanged(db, quote); err != nil {
+	quote, shared, err := fetchSharedQuote(r.Context(), pair)
+	if err != nil {
+		recordFailure(err)
+		if pair == defaultPair && upstreamDown(err) {
+			if settings().serveStale {
+				if quote := newestStoredQuote(); quote != nil {
+					// Past MAX_STALE the stored quote is too wrong to stand in.
+					age, maxStale := staleAge(quote), settings().maxStale
+					if maxStale > 0 && age > maxStale {
+						http.Error(
+							w,
+							fmt.Sprintf(
+								"Failed to fetch quotation: %v; the stored quote is %v old, over MAX_STALE %v",
+								err, age, maxStale,
+							),
+							http.StatusServiceUnavailable,
+						)
+						return
+					}
+					writeStaleQuote(w, r, quote, start)
+					return
+				}
+			}
+			if storeEmpty(r.Context()) {
+				// Nothing has ever been fetched: that's "no quote yet",
+				// not a failure of this server.
+				setCacheControl(w, true)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
 		http.Error(
 			w,
-			fmt.Sprintf("Failed to save quotation: %v", err),
-			http.StatusInternalServerError,
+			fmt.Sprintf("Failed to fetch quotation: %v", err),
+			upstreamStatus(err),
 		)
 		return
 	}
+
+	// Only the default pair is persisted until the history endpoints take a
+	// pair; other pairs are passed through without being stored.
+	saved := true
+	if pair == defaultPair {
+		if err = persistQuote(r.Context(), pair, quote); err != nil {
+			recordFailure(err)
+			if !errors.Is(err, errSaveTimeout) {
+				http.Error(
+					w,
+					fmt.Sprintf("Failed to save quotation: %v", err),
+					http.StatusInternalServerError,
+				)
+				return
+			}
+			// The quote is still good; only storing it took too long.
+			slog.Warn("Serving a quotation that wasn't saved", "error", err)
+			w.Header().Set("X-Quote-Saved", "false")
+			saved = false
+		}
+	}
+	if saved {
+		recordSuccess()
+	}
+
+	verbose := r.URL.Query().Get("verbose") == "true"
+	timing := timingRequested(r)
+	setCacheControl(w, r.URL.Query().Get("force") == "true" || timing)
+	// A quote another request's fetch brought in wasn't fetched for this
+	// one.
+	source := quoteSourceLive
+	if shared {
+		source = quoteSourceCache
+		servedCounters.cacheHits.Add(1)
+	}
+	w.Header().Set("X-Quote-Source", source)
+	// A timed or JSONP body isn't the one the quote's ETag stands for.
+	if !timing && callback == "" {
+		etag := quoteETag(pair, quote)
+		if verbose {
+			// A different body for the same quote needs its own tag.
+			etag = strings.TrimSuffix(etag, `"`) + `-verbose"`
+		}
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
 	response := ClientResponse{
-		Bid: quote.Bid,
+		Bid:    quote.Bid,
+		Source: source,
+		pair:   pair,
+	}
+	if verbose {
+		response.VarBid = quote.VarBid
+		response.PctChange = quote.PctChange
+		response.High = quote.High
+		response.Low = quote.Low
+		response.CreateDate = &quote.CreateDate
 	}
-	responseJSON, err := json.Marshal(response)
+	if timing {
+		setProcessingTime(w, &response, start)
+	}
+	writeQuote(w, r, response)
+}
+
+// writeJSON serializes v as the response body, answering with a 500 when it
+// can't be encoded.
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	responseJSON, err := json.Marshal(v)
 	if err != nil {
 		http.Error(
 			w,
-			fmt.Sprintf("Failed to serialize quotation to JSON: %v", err),
+			fmt.Sprintf("Failed to serialize response to JSON: %v", err),
 			http.StatusInternalServerError,
 		)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(responseJSON)
 }
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if err := loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// quoteBody is a provider response holding pair's quote.
+func quoteBody(pair, bid string, timestamp int64) string {
+	code, codein, _ := strings.Cut(pair, "-")
+	return fmt.Sprintf(
+		`{"%s":{"code":"%s","codein":"%s","bid":"%s","timestamp":"%d","create_date":"2024-05-17 10:30:00"}}`,
+		pairKey(pair), code, codein, bid, timestamp,
+	)
+}
+
+// stubProvider serves handler in place of the provider for pairs, each
+// under its own path, for the rest of the test.
+func stubProvider(t *testing.T, handler http.HandlerFunc, pairs ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	previous := pairURLs
+	pairURLs = map[string]string{}
+	for _, pair := range pairs {
+		pairURLs[pair] = srv.URL + "/" + pair
+	}
+	t.Cleanup(func() { pairURLs = previous })
+	return srv
+}
+
+// useTestDB stores quotes in a fresh database for the rest of the test,
+// starting with nothing remembered about what is stored.
+func useTestDB(t testing.TB) {
+	t.Helper()
+	previous, previousPersist := dbPath, persist
+	dbPath = filepath.Join(t.TempDir(), "quotes.db")
+	persist = true
+	forgetStored()
+	t.Cleanup(func() {
+		dbPath, persist = previous, previousPersist
+		forgetStored()
+	})
+}
+
+func forgetStored() {
+	lastStored.Range(func(key, _ any) bool {
+		lastStored.Delete(key)
+		return true
+	})
+	recentQuotes.invalidate()
+}
+
+// withoutPersistence passes quotes through unstored for the rest of the
+// test.
+func withoutPersistence(t *testing.T) {
+	t.Helper()
+	previous := persist
+	persist = false
+	t.Cleanup(func() { persist = previous })
+}
+
+// storedQuotes counts pair's rows in the test database.
+func storedQuotes(t *testing.T, pair string) int {
+	t.Helper()
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM quotes WHERE pair = ?", pair).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func getQuotation(query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	getDollarQuotationHandler(rec, httptest.NewRequest("GET", "/cotacao"+query, nil))
+	return rec
+}
+
+func TestConcurrentFetchesShareOneUpstreamCall(t *testing.T) {
+	withoutPersistence(t)
+	var hits atomic.Int64
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, quoteBody(defaultPair, "5.12", 1715952600))
+	}, defaultPair)
+
+	const requests = 20
+	var wg sync.WaitGroup
+	statuses := make([]int, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = getQuotation("").Code
+		}()
+	}
+	wg.Wait()
+
+	for i, status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("request %d: status %d, want 200", i, status)
+		}
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestWrongFieldTypeIsBadGateway(t *testing.T) {
+	withoutPersistence(t)
+	for name, body := range map[string]string{
+		"numeric bid":         `{"USDBRL":{"bid":5.12,"timestamp":"1715952600","create_date":"2024-05-17 10:30:00"}}`,
+		"missing bid":         `{"USDBRL":{"timestamp":"1715952600","create_date":"2024-05-17 10:30:00"}}`,
+		"numeric create_date": `{"USDBRL":{"bid":"5.12","timestamp":"1715952600","create_date":1715952600}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, body)
+			}, defaultPair)
+
+			rec := getQuotation("")
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status %d, want 502: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestConcurrentRequestsStoreOneQuote(t *testing.T) {
+	useTestDB(t)
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, quoteBody(defaultPair, "5.12", 1715952600))
+	}, defaultPair)
+	srv := httptest.NewServer(http.HandlerFunc(getDollarQuotationHandler))
+	defer srv.Close()
+
+	// Connections are capped so the requests race each other rather than
+	// the dial, which would eat into their 200ms upstream budget.
+	client := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 20}}
+	const requests = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL + "/cotacao")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				errs <- fmt.Errorf("status %d: %s", resp.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := storedQuotes(t, defaultPair); got != 1 {
+		t.Errorf("%d rows stored, want 1", got)
+	}
+}
+
+// TestConcurrentSavesInsertOnce races saveIfChanged itself, which the
+// in-memory lastStored would otherwise keep most requests away from.
+func TestConcurrentSavesInsertOnce(t *testing.T) {
+	useTestDB(t)
+	quote := &Quote{Bid: 5.12, Timestamp: 1715952600, CreateDate: time.Unix(1715952600, 0).UTC()}
+
+	const savers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, savers)
+	for range savers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := openDB()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+			// A save that timed out waiting for the lock is tried again, as
+			// the next request would.
+			for attempt := 0; ; attempt++ {
+				err = saveIfChanged(context.Background(), db, defaultPair, quote)
+				if !errors.Is(err, errSaveTimeout) || attempt == 20 {
+					break
+				}
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := storedQuotes(t, defaultPair); got != 1 {
+		t.Errorf("%d rows stored, want 1", got)
+	}
+}
+
+func TestEmptyBidIsBadGateway(t *testing.T) {
+	withoutPersistence(t)
+	for name, bid := range map[string]string{"empty": "", "whitespace": "  "} {
+		t.Run(name, func(t *testing.T) {
+			stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, quoteBody(defaultPair, bid, 1715952600))
+			}, defaultPair)
+
+			rec := getQuotation("")
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status %d, want 502", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), "upstream returned empty bid") {
+				t.Errorf("body %q doesn't name the empty bid", rec.Body)
+			}
+		})
+	}
+}
+
+// cutShort answers with a Content-Length longer than the body it sends
+// before closing the connection.
+func cutShort(w http.ResponseWriter) {
+	body := quoteBody(defaultPair, "5.12", 1715952600)
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:len(body)/2])
+	buf.Flush()
+}
+
+func withUpstreamRetries(t *testing.T, retries int) {
+	t.Helper()
+	previous := upstreamRetries
+	upstreamRetries = retries
+	t.Cleanup(func() { upstreamRetries = previous })
+}
+
+func TestTruncatedUpstreamResponse(t *testing.T) {
+	withoutPersistence(t)
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		cutShort(w)
+	}, defaultPair)
+
+	rec := getQuotation("")
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "truncated upstream response") {
+		t.Errorf("body %q doesn't report the truncation", rec.Body)
+	}
+}
+
+func TestTruncatedUpstreamResponseIsRetried(t *testing.T) {
+	withoutPersistence(t)
+	withUpstreamRetries(t, 1)
+	var hits atomic.Int64
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			cutShort(w)
+			return
+		}
+		fmt.Fprint(w, quoteBody(defaultPair, "5.12", 1715952600))
+	}, defaultPair)
+
+	if rec := getQuotation(""); rec.Code != http.StatusOK {
+		t.Errorf("status %d, want 200 after a retry: %s", rec.Code, rec.Body)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream called %d times, want 2", got)
+	}
+}
+
+// holdWriteLock takes the database's write lock on its own connection, as
+// another process would, until the test ends.
+func holdWriteLock(t *testing.T) {
+	t.Helper()
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		conn.Close()
+		db.Close()
+	})
+}
+
+func TestCheckDatabaseReportsLock(t *testing.T) {
+	useTestDB(t)
+	holdWriteLock(t)
+
+	if err := checkDatabase(); !errors.Is(err, errDatabaseLocked) {
+		t.Errorf("checkDatabase() = %v, want errDatabaseLocked", err)
+	}
+}
+
+func TestCheckDatabaseProceedsInWALMode(t *testing.T) {
+	useTestDB(t)
+	previous := walEnabled
+	walEnabled = true
+	t.Cleanup(func() { walEnabled = previous })
+	if err := checkDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	holdWriteLock(t)
+
+	if err := checkDatabase(); err != nil {
+		t.Errorf("checkDatabase() = %v in WAL mode, want nil", err)
+	}
+}
+
+func TestCreateDateIsStoredAsUTC(t *testing.T) {
+	previous := upstreamLocation
+	t.Cleanup(func() { upstreamLocation = previous })
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(quoteBody(defaultPair, "5.12", 1715952600)), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	for zone, want := range map[string]string{
+		"America/Sao_Paulo": "2024-05-17T13:30:00Z",
+		"UTC":               "2024-05-17T10:30:00Z",
+		"Asia/Tokyo":        "2024-05-17T01:30:00Z",
+	} {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upstreamLocation = location
+
+		quote, err := parseQuote(data, defaultPair)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := quote.CreateDate.Format(time.RFC3339); got != want {
+			t.Errorf("UPSTREAM_TZ=%s: create_date %s, want %s", zone, got, want)
+		}
+	}
+}
+
+func TestEmptyUpstreamBody(t *testing.T) {
+	withoutPersistence(t)
+	withUpstreamRetries(t, 1)
+	var hits atomic.Int64
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}, defaultPair)
+
+	rec := getQuotation("")
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "empty upstream response") {
+		t.Errorf("body %q doesn't report the empty response", rec.Body)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream called %d times, want 2 with one retry", got)
+	}
+}
+
+func withMinChange(t *testing.T, threshold float64, percent bool) {
+	t.Helper()
+	previous, previousPercent := minChange, minChangePercent
+	minChange, minChangePercent = threshold, percent
+	t.Cleanup(func() { minChange, minChangePercent = previous, previousPercent })
+}
+
+func TestMinChangeBoundary(t *testing.T) {
+	current := Quote{Bid: 5.0, Timestamp: 100}
+	for _, tc := range []struct {
+		name      string
+		threshold float64
+		percent   bool
+		bid       float64
+		want      bool
+	}{
+		{"below absolute", 0.01, false, 5.009, false},
+		{"at absolute", 0.01, false, 5.01, false},
+		{"above absolute", 0.01, false, 5.0101, true},
+		{"at absolute, falling", 0.01, false, 4.99, false},
+		{"above absolute, falling", 0.01, false, 4.9899, true},
+		{"at percentage", 1, true, 5.05, false},
+		{"above percentage", 1, true, 5.0501, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withMinChange(t, tc.threshold, tc.percent)
+			// The timestamp changes too, which MIN_CHANGE disregards.
+			if got := quoteChanged(current, Quote{Bid: tc.bid, Timestamp: 200}); got != tc.want {
+				t.Errorf("quoteChanged from 5.0 to %v = %v, want %v", tc.bid, got, tc.want)
+			}
+		})
+	}
+
+	withMinChange(t, 0, false)
+	if quoteChanged(current, Quote{Bid: 5.0, Timestamp: 200}) != true {
+		t.Error("without MIN_CHANGE a new timestamp should be stored")
+	}
+}
+
+func TestMinChangeSkipsSmallMoves(t *testing.T) {
+	useTestDB(t)
+	withMinChange(t, 0.01, false)
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i, bid := range []float64{5.0, 5.01, 5.02, 5.0201} {
+		quote := &Quote{Bid: bid, Timestamp: int64(1715952600 + i), CreateDate: time.Unix(1715952600, 0).UTC()}
+		if err := saveIfChanged(context.Background(), db, defaultPair, quote); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 5.01 moved exactly 0.01 from the stored 5.0 and 5.0201 barely moved
+	// from the stored 5.02, so only 5.0 and 5.02 are stored.
+	if got := storedQuotes(t, defaultPair); got != 2 {
+		t.Errorf("%d rows stored, want 2", got)
+	}
+}
+
+func TestUnparsableTimestampIsBadGateway(t *testing.T) {
+	withoutPersistence(t)
+	for name, tc := range map[string]struct{ timestamp, want string }{
+		"overflowing": {"99999999999999999999", `upstream timestamp "99999999999999999999" is out of range`},
+		"non-integer": {"1715952600.5", `upstream timestamp "1715952600.5" is not an integer`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"USDBRL":{"bid":"5.12","timestamp":"%s","create_date":"2024-05-17 10:30:00"}}`, tc.timestamp)
+			}, defaultPair)
+
+			rec := getQuotation("")
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status %d, want 502", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tc.want) {
+				t.Errorf("body %q, want it to contain %q", rec.Body, tc.want)
+			}
+		})
+	}
+}
+
+func TestWrongPairIsBadGateway(t *testing.T) {
+	withoutPersistence(t)
+	for name, tc := range map[string]struct{ body, want string }{
+		"other key": {
+			quoteBody("EUR-BRL", "6.01", 1715952600),
+			"upstream returned wrong pair: asked for USDBRL, got EURBRL",
+		},
+		"other code under the key": {
+			strings.Replace(quoteBody("EUR-BRL", "6.01", 1715952600), `"EURBRL"`, `"USDBRL"`, 1),
+			"upstream returned wrong pair: asked for USD-BRL, got EUR-BRL",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tc.body)
+			}, defaultPair)
+
+			rec := getQuotation("")
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status %d, want 502", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tc.want) {
+				t.Errorf("body %q, want it to contain %q", rec.Body, tc.want)
+			}
+		})
+	}
+}
+
+// TestMultiStoresOnlyDefaultPair fetches USD-BRL and EUR-BRL through
+// /cotacao/multi with the ring loaded, and checks EUR-BRL reaches none of
+// the stores that only hold the default pair.
+func TestMultiStoresOnlyDefaultPair(t *testing.T) {
+	useTestDB(t)
+	const ts = 1715952600
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		pair := strings.TrimPrefix(r.URL.Path, "/")
+		bid := "6.01"
+		if pair == defaultPair {
+			bid = "5.12"
+		}
+		fmt.Fprint(w, quoteBody(pair, bid, ts))
+	}, defaultPair, "EUR-BRL")
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	recentQuotes.ensureLoaded(db)
+
+	rec := httptest.NewRecorder()
+	multiQuoteHandler(rec, httptest.NewRequest("GET", "/cotacao/multi?pairs=USD-BRL,EUR-BRL", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("X-Quote-Saved") != "" {
+		t.Error("USD-BRL should have been saved")
+	}
+	for pair, want := range map[string]int{defaultPair: 1, "EUR-BRL": 0} {
+		if got := storedQuotes(t, pair); got != want {
+			t.Errorf("%d %s rows stored, want %d", got, pair, want)
+		}
+	}
+
+	from, to := time.Unix(ts, 0).Add(-time.Hour), time.Unix(ts, 0).Add(time.Hour)
+	history, _, ok := recentHistory(historyQuery{from: from, to: to, limit: 10})
+	if !ok {
+		t.Fatal("ring did not cover the window")
+	}
+	if len(history) != 1 || history[0].Bid != 5.12 {
+		t.Errorf("ring history %+v, want only the USD-BRL quote", history)
+	}
+	candles, ok := recentCandles(from, to, time.Hour, false)
+	if !ok {
+		t.Fatal("ring did not cover the window")
+	}
+	for _, candle := range candles {
+		if candle.H != 5.12 || candle.L != 5.12 {
+			t.Errorf("ring candle %+v, want only the USD-BRL bid", candle)
+		}
+	}
+	events, err := queryEvents(db, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Bid != 5.12 {
+		t.Errorf("events %+v, want only the USD-BRL quote's", events)
+	}
+}
+
+func TestSharedFetchIsSourcedFromCache(t *testing.T) {
+	withoutPersistence(t)
+	release := make(chan struct{})
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, quoteBody(defaultPair, "5.12", 1715952600))
+	}, defaultPair)
+
+	const requests = 5
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = getQuotation("")
+		}()
+	}
+	// Let every request join the fetch before the provider answers.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	sources := map[string]int{}
+	for _, rec := range recs {
+		source := rec.Header().Get("X-Quote-Source")
+		if !strings.Contains(rec.Body.String(), `"source":"`+source+`"`) {
+			t.Errorf("body %s doesn't match X-Quote-Source %q", rec.Body, source)
+		}
+		sources[source]++
+	}
+	if sources[quoteSourceLive] != 1 || sources[quoteSourceCache] != requests-1 {
+		t.Errorf("sources %v, want one live and %d cache", sources, requests-1)
+	}
+}
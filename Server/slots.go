@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// upstreamConcurrency caps how many upstream fetches run at once, so a
+// burst of requests can't exceed the provider's rate limit. Zero leaves
+// them unlimited.
+var upstreamConcurrency = 4
+
+// upstreamSlots holds one token per fetch in flight.
+var upstreamSlots chan struct{}
+
+// acquireUpstream waits, until ctx is done, for room to fetch from the
+// provider, and returns the function that gives it back. Running out of
+// time counts as the provider being unavailable, so SERVE_STALE applies.
+func acquireUpstream(ctx context.Context) (func(), error) {
+	if upstreamSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case upstreamSlots <- struct{}{}:
+		return func() { <-upstreamSlots }, nil
+	case <-ctx.Done():
+		return nil, unavailableUpstream(fmt.Errorf(
+			"too many upstream fetches in flight (UPSTREAM_CONCURRENCY=%d): %w",
+			upstreamConcurrency, ctx.Err(),
+		))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// newestStoredQuote returns the stored quote with the newest timestamp, or
+// nil when there is none or the database can't be read.
+func newestStoredQuote() *Quote {
+	if !persist {
+		return nil
+	}
+	db, err := openDB()
+	if err != nil {
+		slog.Warn("Could not read a stale quote", "error", err)
+		return nil
+	}
+	defer db.Close()
+
+	quote, err := latestStoredQuote(db)
+	if err != nil {
+		slog.Warn("Could not read a stale quote", "error", err)
+		return nil
+	}
+	return quote
+}
+
+// staleAge is how long ago quote was taken, in whole seconds since that is
+// what its timestamp holds.
+func staleAge(quote *Quote) time.Duration {
+	return time.Duration(max(clock().Unix()-quote.Timestamp, 0)) * time.Second
+}
+
+// writeStaleQuote answers with a stored quote in place of a live one. The
+// body says so, with stale and age_seconds, so clients notice without
+// looking at headers; no cache may keep it, and it has no ETag since it
+// isn't the current quote.
+func writeStaleQuote(w http.ResponseWriter, r *http.Request, quote *Quote, start time.Time) {
+	age := int64(staleAge(quote) / time.Second)
+	setCacheControl(w, true)
+	w.Header().Set("X-Quote-Source", quoteSourceDB)
+
+	response := ClientResponse{
+		Bid:        quote.Bid,
+		Source:     quoteSourceDB,
+		Stale:      true,
+		AgeSeconds: &age,
+		pair:       defaultPair,
+	}
+	if r.URL.Query().Get("verbose") == "true" {
+		response.VarBid = quote.VarBid
+		response.PctChange = quote.PctChange
+		response.High = quote.High
+		response.Low = quote.Low
+		response.CreateDate = &quote.CreateDate
+	}
+	if timingRequested(r) {
+		setProcessingTime(w, &response, start)
+	}
+	writeQuote(w, r, response)
+}
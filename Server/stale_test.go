@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withSettings runs the rest of the test with change applied to the
+// reloadable settings.
+func withSettings(t *testing.T, change func(s *reloadableSettings)) {
+	t.Helper()
+	previous := settings()
+	next := *previous
+	change(&next)
+	liveSettings.Store(&next)
+	t.Cleanup(func() { liveSettings.Store(previous) })
+}
+
+func withClock(t testing.TB, now time.Time) {
+	t.Helper()
+	previous := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = previous })
+}
+
+func TestMaxStaleBoundary(t *testing.T) {
+	useTestDB(t)
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("down"))
+	}, defaultPair)
+	taken := time.Unix(1715952600, 0)
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := saveIfChanged(context.Background(), db, defaultPair, &Quote{Bid: 5.12, Timestamp: taken.Unix(), CreateDate: taken.UTC()}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		maxStale time.Duration
+		age      time.Duration
+		want     int
+	}{
+		{"under MAX_STALE", 10 * time.Minute, 10*time.Minute - time.Second, http.StatusOK},
+		{"at MAX_STALE", 10 * time.Minute, 10 * time.Minute, http.StatusOK},
+		{"over MAX_STALE", 10 * time.Minute, 10*time.Minute + time.Second, http.StatusServiceUnavailable},
+		{"without MAX_STALE", 0, 24 * time.Hour, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withSettings(t, func(s *reloadableSettings) {
+				s.serveStale = true
+				s.maxStale = tc.maxStale
+			})
+			withClock(t, taken.Add(tc.age))
+
+			rec := getQuotation("")
+			if rec.Code != tc.want {
+				t.Fatalf("at age %v: status %d, want %d: %s", tc.age, rec.Code, tc.want, rec.Body)
+			}
+			if tc.want == http.StatusOK && !strings.Contains(rec.Body.String(), `"stale":true`) {
+				t.Errorf("body %s not flagged stale", rec.Body)
+			}
+			if tc.want == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), "over MAX_STALE") {
+				t.Errorf("body %q doesn't name MAX_STALE", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// servedCounters measures how effective caching is. upstreamFetches counts
+// every request sent to a provider, retries and batches included, whoever
+// asked for it; cacheHits counts quote requests answered without one: the
+// /cotacao answers with source cache, shared from a fetch already in
+// flight, and /cotacao/wait answers from the polled quote. A shared fetch
+// that fails answers nobody, so it counts no hits.
+var servedCounters struct {
+	cacheHits       atomic.Int64
+	upstreamFetches atomic.Int64
+}
+
+type InternalStatsResponse struct {
+	CacheHits       int64 `json:"cache_hits"`
+	UpstreamFetches int64 `json:"upstream_fetches"`
+	// RecentHits and RecentMisses count history and OHLC requests answered
+	// from the in-memory recent quotes versus by querying the database.
+	RecentHits   int64 `json:"recent_hits"`
+	RecentMisses int64 `json:"recent_misses"`
+}
+
+func internalStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, InternalStatsResponse{
+		CacheHits:       servedCounters.cacheHits.Load(),
+		UpstreamFetches: servedCounters.upstreamFetches.Load(),
+		RecentHits:      recentQuotes.hits.Load(),
+		RecentMisses:    recentQuotes.misses.Load(),
+	})
+}
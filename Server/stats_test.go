@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// requestsSharing is how many concurrent requests share one fetch.
+const requestsSharing = 10
+
+func TestCountersSeparateSharedFetches(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status   int
+		wantHits int64
+	}{
+		"success": {http.StatusOK, requestsSharing - 1},
+		// Followers of a failed fetch got no quote, so none is a hit.
+		"upstream failure": {http.StatusBadGateway, 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withoutPersistence(t)
+			release := make(chan struct{})
+			stubProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				<-release
+				if tc.status != http.StatusOK {
+					w.WriteHeader(tc.status)
+					return
+				}
+				fmt.Fprint(w, quoteBody(defaultPair, "5.12", 1715952600))
+			}, defaultPair)
+			hits, fetches := servedCounters.cacheHits.Load(), servedCounters.upstreamFetches.Load()
+
+			var wg sync.WaitGroup
+			for range requestsSharing {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					getQuotation("")
+				}()
+			}
+			// Let every request join the fetch before the provider answers.
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := servedCounters.upstreamFetches.Load() - fetches; got != 1 {
+				t.Errorf("upstream_fetches rose by %d, want 1", got)
+			}
+			if got := servedCounters.cacheHits.Load() - hits; got != tc.wantHits {
+				t.Errorf("cache_hits rose by %d, want %d", got, tc.wantHits)
+			}
+		})
+	}
+}
+
+func TestCountersCountRetries(t *testing.T) {
+	withoutPersistence(t)
+	withUpstreamRetries(t, 1)
+	stubProvider(t, func(w http.ResponseWriter, r *http.Request) {}, defaultPair)
+	hits, fetches := servedCounters.cacheHits.Load(), servedCounters.upstreamFetches.Load()
+
+	getQuotation("")
+	if got := servedCounters.upstreamFetches.Load() - fetches; got != 2 {
+		t.Errorf("upstream_fetches rose by %d, want 2 for a fetch and its retry", got)
+	}
+	if got := servedCounters.cacheHits.Load() - hits; got != 0 {
+		t.Errorf("cache_hits rose by %d, want 0", got)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// timingRequested reports whether the request asked, with timing=true, for
+// the time the handler took. Such an answer belongs to that request alone,
+// so it is neither cached nor tagged.
+func timingRequested(r *http.Request) bool {
+	return r.URL.Query().Get("timing") == "true"
+}
+
+// setProcessingTime fills in server_processing_ms, and the matching
+// Server-Timing header, with the wall time since start. Call it right
+// before writing the response.
+func setProcessingTime(w http.ResponseWriter, response *ClientResponse, start time.Time) {
+	ms := math.Round(float64(time.Since(start).Microseconds())) / 1000
+	response.ServerProcessingMs = &ms
+	w.Header().Set("Server-Timing", fmt.Sprintf("handler;dur=%.3f", ms))
+}
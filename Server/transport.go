@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	// upstreamDialTimeout and upstreamTLSTimeout bound connecting to the
+	// provider, so a dead host fails well before timeoutAPI, which still
+	// caps the whole request.
+	upstreamDialTimeout = 100 * time.Millisecond
+	upstreamTLSTimeout  = 100 * time.Millisecond
+)
+
+// upstreamClient fetches from the providers. loadConfig rebuilds it once
+// the timeouts are read.
+var upstreamClient = newUpstreamClient()
+
+func newUpstreamClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: upstreamDialTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = upstreamTLSTimeout
+	return &http.Client{Transport: transport, CheckRedirect: checkUpstreamRedirect}
+}
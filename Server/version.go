@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// serverVersion is read once from the build info Go embeds in the binary:
+// the module version ("(devel)" for a local build) and the VCS revision it
+// was built from, when known.
+var serverVersion = readVersion()
+
+func readVersion() VersionResponse {
+	version := VersionResponse{Version: "unknown", GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	version.Version = info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version.Revision = setting.Value
+		}
+	}
+	return version
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, serverVersion)
+}
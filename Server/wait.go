@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// quoteUpdates announces the quotes the poller stores, for long-polling
+// clients.
+var quoteUpdates = newQuoteNotifier()
+
+// quoteNotifier holds the newest polled quote. Every newer quote closes
+// changed, waking all waiters, and replaces it with a fresh channel.
+type quoteNotifier struct {
+	mu      sync.Mutex
+	latest  *Quote
+	changed chan struct{}
+	done    chan struct{}
+}
+
+func newQuoteNotifier() *quoteNotifier {
+	return &quoteNotifier{changed: make(chan struct{}), done: make(chan struct{})}
+}
+
+// stop releases every waiter, so long-polls don't hold up shutdown.
+func (n *quoteNotifier) stop() {
+	close(n.done)
+}
+
+func (n *quoteNotifier) publish(quote *Quote) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.latest != nil && quote.Timestamp <= n.latest.Timestamp {
+		return
+	}
+	n.latest = quote
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// wait returns the first quote newer than since, blocking until one is
+// published, ctx is done or the notifier is stopped.
+func (n *quoteNotifier) wait(ctx context.Context, since int64) (*Quote, bool) {
+	for {
+		n.mu.Lock()
+		latest, changed := n.latest, n.changed
+		n.mu.Unlock()
+		if latest != nil && latest.Timestamp > since {
+			return latest, true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, false
+		case <-n.done:
+			return nil, false
+		}
+	}
+}
+
+// longPollTimeout bounds how long /cotacao/wait holds a request.
+var longPollTimeout = 30 * time.Second
+
+// waitQuoteHandler answers with the newest polled quote once its timestamp
+// is past since, holding the request for up to longPollTimeout; after that
+// it answers 204.
+func waitQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if quotePoller == nil {
+		http.Error(w, "Long-polling needs the background poller: set POLL_INTERVAL or POLL_CRON", http.StatusServiceUnavailable)
+		return
+	}
+
+	var since int64
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		var err error
+		if since, err = strconv.ParseInt(raw, 10, 64); err != nil || since < 0 {
+			http.Error(w, fmt.Sprintf("Invalid since %q: expected a Unix timestamp", raw), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), longPollTimeout)
+	defer cancel()
+	quote, ok := quoteUpdates.wait(ctx, since)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	servedCounters.cacheHits.Add(1)
+	writeJSON(w, quote)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+// warmUpstream makes startup fetch and store one quote before serving, so
+// the first request doesn't pay for dialing the provider.
+var warmUpstream bool
+
+// warmCaches fills the in-memory state from the database before the server
+// takes traffic: recentQuotes is loaded and the newest stored quote is
+// handed to quoteUpdates, the dollar_bid gauge and lastStored. With
+// warmUpstream it then polls the provider once. Failures are logged and
+// startup carries on cold.
+func warmCaches(ctx context.Context) {
+	if persist {
+		warmFromDatabase()
+	}
+
+	if warmUpstream {
+		if _, err := pollQuote(ctx); err == nil {
+			slog.Info("Warmed up the upstream connection")
+		}
+	}
+}
+
+func warmFromDatabase() {
+	db, err := openDB()
+	if err != nil {
+		slog.Warn("Could not warm caches", "error", err)
+		return
+	}
+	recentQuotes.ensureLoaded(db)
+	latest, err := latestStoredQuote(db)
+	db.Close()
+	switch {
+	case err != nil:
+		slog.Warn("Could not warm caches", "error", err)
+	case latest != nil:
+		quoteUpdates.publish(latest)
+		recordStoredBid(defaultPair, latest)
+		rememberStored(defaultPair, latest)
+	}
+}
+
+// latestStoredQuote returns the stored quote with the newest timestamp, or
+// nil when none is stored.
+func latestStoredQuote(db *sql.DB) (*Quote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutQuery)
+	defer cancel()
+
+	quotes, err := latestQuotes(ctx, db, defaultPair, 1)
+	if err != nil || len(quotes) == 0 {
+		return nil, err
+	}
+	return &quotes[0], nil
+}
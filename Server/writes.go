@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// writeDrainTimeout bounds how long shutdown waits for quote writes still
+// in flight once requests and the poller have stopped.
+const writeDrainTimeout = 5 * time.Second
+
+var errWritesClosed = errors.New("server is shutting down and no longer stores quotes")
+
+// quoteWrites tracks the quote inserts and imports in flight, so shutdown
+// can refuse new ones and wait for the rest before the process exits.
+var quoteWrites writeTracker
+
+type writeTracker struct {
+	mu       sync.Mutex
+	closed   bool
+	inFlight int
+	flushed  int
+	drained  chan struct{}
+}
+
+// begin registers a write about to start, or reports false once draining
+// has begun. Every successful begin must be paired with end.
+func (t *writeTracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.inFlight++
+	return true
+}
+
+func (t *writeTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	if !t.closed {
+		return
+	}
+	t.flushed++
+	if t.inFlight == 0 {
+		close(t.drained)
+	}
+}
+
+// drain refuses further writes and waits up to timeout for those in
+// flight. It returns how many finished while it waited and how many were
+// still running, and so lost, when it gave up.
+func (t *writeTracker) drain(timeout time.Duration) (flushed, dropped int) {
+	t.mu.Lock()
+	t.closed = true
+	t.drained = make(chan struct{})
+	if t.inFlight == 0 {
+		close(t.drained)
+	}
+	t.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-t.drained:
+	case <-timer.C:
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flushed, t.inFlight
+}